@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"nano_client"
+	"os"
+	"time"
+)
+
+// Exit codes by error category. Errors reported by the node use exitNode.
+var exitCodes = map[string]int{
+	"Connection":  2,
+	"Network":     3,
+	"Marshalling": 4,
+	"Context":     5,
+	"Busy":        6,
+	"RateLimited": 6,
+}
+
+const exitUsage = 1
+const exitNode = 7
+
+// Sends a single request to a Nano node and prints the JSON response.
+// Usage: nano-cli [-connection local:///tmp/nano] [-timeout 30s] <request type> [json file]
+// The request type is given without the req_ prefix, such as account_pending. The JSON
+// request body is read from the file, or from stdin if no file is given.
+func main() {
+	connectionString := flag.String("connection", nano_client.UnixAddr("/tmp/nano"), "Node connection string")
+	timeout := flag.Duration("timeout", 30*time.Second, "Connection and request timeout")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: nano-cli [flags] <request type> [json file]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 || flag.NArg() > 2 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+
+	var body []byte
+	var err error
+	if flag.NArg() == 2 {
+		body, err = ioutil.ReadFile(flag.Arg(1))
+	} else {
+		body, err = ioutil.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
+
+	session := &nano_client.Session{ConnTimeout: *timeout, RWTimeout: *timeout}
+	if err := session.Connect(*connectionString); err != nil {
+		exit(err)
+	}
+	defer session.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	result, reqErr := session.RequestJSONContext(ctx, flag.Arg(0), body)
+	if reqErr != nil {
+		session.Close()
+		exit(reqErr)
+	}
+	fmt.Println(string(result))
+}
+
+// Prints the error and exits with the code of its category
+func exit(err *nano_client.Error) {
+	fmt.Fprintln(os.Stderr, err.Error())
+	code, ok := exitCodes[err.Category]
+	if errors.Is(err, nano_client.ErrNodeBusy) {
+		code, ok = exitCodes["Busy"], true
+	}
+	if !ok {
+		code = exitNode
+	}
+	os.Exit(code)
+}
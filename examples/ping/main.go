@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 	"log"
-	"nano_api"
 	"nano_client"
 	"os"
 	"time"
@@ -13,34 +12,24 @@ import (
 // Program argument is a connection string such as local:///tmp/nano
 // for unix domain sockets and tcp://localhost:7077 for tcp sockets.
 func main() {
-	connectionString := "local:///tmp/nano"
+	connectionString := nano_client.UnixAddr("/tmp/nano")
 	if len(os.Args) > 1 {
 		connectionString = os.Args[1]
 	}
 
 	session := &nano_client.Session{}
-	session.TimeoutConnection = 2
+	session.ConnTimeout = 2 * time.Second
 
-	if session.Connect(connectionString) != nil {
-		log.Println(session.LastError.Error())
+	if err := session.Connect(connectionString); err != nil {
+		log.Println(err.Error())
 	} else {
 		defer session.Close()
 
 		log.Printf("Ping loop in progress...")
-		start := time.Now()
-		for i := 0; i < 10000; i++ {
-			ping := &nano_api.ReqPing{
-				Id: 1000,
-			}
-			pong := &nano_api.ResPing{}
-
-			err := session.Request(ping, pong)
-			if err != nil {
-				fmt.Println(err.Error())
-			}
+		avg, min, max, err := session.PingN(10000)
+		if err != nil {
+			fmt.Println(err.Error())
 		}
-
-		elapsed := time.Since(start)
-		log.Printf("Avg. ping roundtrip time including marshalling: %s", elapsed/10000)
+		log.Printf("Ping roundtrip time including marshalling: avg %s, min %s, max %s", avg, min, max)
 	}
 }
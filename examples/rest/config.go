@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Loads the config from path into conf. If path is a directory, all .json files in it
+// are loaded in lexical order, with later files overriding the settings of earlier ones.
+// A path of - reads the config from stdin, and http and https URLs are fetched.
+// Errors name the file which failed.
+func loadConfig(path string, conf *_Conf) error {
+	if path == "-" {
+		return parseConfig("stdin", os.Stdin, conf)
+	}
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		client := &http.Client{Timeout: 30 * time.Second}
+		resp, err := client.Get(path)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s: %s", path, resp.Status)
+		}
+		return parseConfig(path, resp.Body, conf)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(path, "*.json")); err != nil {
+			return err
+		}
+		sort.Strings(files)
+	}
+
+	for _, file := range files {
+		reader, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		err = parseConfig(file, reader, conf)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parses the config read from reader into conf. The name identifies the source in errors.
+func parseConfig(name string, reader io.Reader, conf *_Conf) error {
+	configBytes, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	if err := json.Unmarshal(configBytes, conf); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
@@ -1,69 +1,172 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"nano_api"
 	"nano_client"
 	"net/http"
-	"reflect"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync/atomic"
-
-	"github.com/golang/protobuf/jsonpb"
-	"github.com/golang/protobuf/proto"
+	"syscall"
+	"time"
 )
 
 // RestServer is a REST interface to the Node API
 type RestServer struct {
+	// Counter for request ids. First for 64-bit alignment of atomic operations.
+	nextrequest int64
 	// Session pool
 	sessions    []*nano_client.Session
 	nextsession int32
 	conf        *_Conf
 }
 
+// _ErrorResponse is the JSON shape of all error responses, identifying the request
+// type and request id along with the error
+type _ErrorResponse struct {
+	Type      string                `json:"type"`
+	RequestId string                `json:"requestId"`
+	Error     _ErrorResponseDetails `json:"error"`
+}
+
+type _ErrorResponseDetails struct {
+	Code     int    `json:"code"`
+	Message  string `json:"message"`
+	Category string `json:"category"`
+	// The base64 encoded response body if it failed to unmarshal and rawonerror is set
+	Raw []byte `json:"raw,omitempty"`
+}
+
 type _Conf struct {
 	Port     int       `json:"port"`
 	Hostname string    `json:"hostname"`
 	Node     _ConfNode `json:"node"`
+	// Timeout in seconds per request type, such as "account_pending". It's a deadline on
+	// the whole request, including redialing, and the session's connection is closed if
+	// it expires. Request types not listed use the session's read and write timeout.
+	Timeouts map[string]int `json:"timeouts"`
+	// If true, JSON responses are written to the client while being marshalled,
+	// rather than buffered in full. This lowers memory use for large responses.
+	Stream bool `json:"stream"`
+	// If true, a response body which fails to unmarshal, such as due to a version
+	// mismatch with the node, is included in the error response
+	RawOnError bool `json:"rawonerror"`
 }
 
 type _ConfNode struct {
 	Connection string `json:"connection"`
 	Poolsize   int    `json:"poolsize"`
+	// Connection timeout in seconds. Default is the session default.
+	ConnTimeout int `json:"conntimeout"`
+	// Default read and write timeout in seconds. Default is the session default.
+	RWTimeout int `json:"rwtimeout"`
+	// Optional role of the node, such as "replica", which prefixes session log messages
+	Label string `json:"label"`
 }
 
-// Start serving requests
-func (server *RestServer) listen() {
+// Start serving requests. The configPath is passed to loadConfig, see there for the
+// supported sources. If empty, config.json is used if it exists.
+func (server *RestServer) listen(configPath string) {
 
 	server.conf = &_Conf{
 		Hostname: "", Port: 8080,
-		Node: _ConfNode{Connection: "local:///tmp/nano", Poolsize: 1},
+		Node: _ConfNode{Connection: nano_client.UnixAddr("/tmp/nano"), Poolsize: 1},
 	}
 
-	if configBytes, err := ioutil.ReadFile("config.json"); err != nil {
-		log.Print("No config file found, using defaults")
-	} else {
-		if err := json.Unmarshal(configBytes, server.conf); err != nil {
+	if configPath == "" {
+		if _, err := os.Stat("config.json"); err != nil {
+			log.Print("No config file found, using defaults")
+		} else {
+			configPath = "config.json"
+		}
+	}
+	if configPath != "" {
+		if err := loadConfig(configPath, server.conf); err != nil {
 			log.Fatal(err)
 		}
 	}
 
+	// Fail at startup rather than on the first request if the protobuf types are broken
+	if err := nano_api.Init(); err != nil {
+		log.Fatal(err)
+	}
 	server.tryConnectNode()
 
 	http.HandleFunc("/", server.handler)
-	http.ListenAndServe(server.conf.Hostname+":"+strconv.Itoa(server.conf.Port), nil)
+	httpServer := &http.Server{Addr: server.conf.Hostname + ":" + strconv.Itoa(server.conf.Port)}
+
+	// On interrupt, stop accepting requests and let those in progress finish. The
+	// sessions are closed once Shutdown returns, as handlers may still be using them
+	// after ListenAndServe returns.
+	done := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		httpServer.Shutdown(context.Background())
+		close(done)
+	}()
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-done
+	if err := server.shutdown(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Closes the pooled sessions. Sessions which fail to close cleanly are logged, and
+// their errors are returned joined.
+func (server *RestServer) shutdown() error {
+	var errs []error
+	for _, session := range server.sessions {
+		node := fmt.Sprint(session.RemoteAddr())
+		if session.Label != "" {
+			node += " (" + session.Label + ")"
+		}
+		if err := session.Close(); err != nil {
+			log.Printf("Closing session to %s: %v", node, err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
-// Try connecting to the Nano node
+// Creates the session pool and connects each session to the Nano node. Sessions which
+// fail to connect are kept, and are reconnected by the handler when used. Sessions of a
+// previous pool are closed. Returns the first error, if any.
 func (server *RestServer) tryConnectNode() *nano_client.Error {
+	// All sessions are cloned from the template so they're configured identically
+	template := &nano_client.Session{
+		ConnTimeout:          time.Duration(server.conf.Node.ConnTimeout) * time.Second,
+		RWTimeout:            time.Duration(server.conf.Node.RWTimeout) * time.Second,
+		ReturnPartialOnError: server.conf.RawOnError,
+		Logger:               log.Default(),
+		Label:                server.conf.Node.Label,
+	}
+
+	for _, session := range server.sessions {
+		session.Close()
+	}
+
 	var err *nano_client.Error
-	server.sessions = make([]*nano_client.Session, 0)
-	for i := 0; err == nil && i < server.conf.Node.Poolsize; i++ {
-		session := &nano_client.Session{}
+	server.sessions = make([]*nano_client.Session, 0, server.conf.Node.Poolsize)
+	for i := 0; i < server.conf.Node.Poolsize; i++ {
+		session := template.Clone()
 		server.sessions = append(server.sessions, session)
-		err = session.Connect(server.conf.Node.Connection)
+		if connErr := session.Connect(server.conf.Node.Connection); connErr != nil && err == nil {
+			err = connErr
+		}
 	}
 	if err != nil {
 		log.Print(err.Message)
@@ -85,68 +188,104 @@ func (server *RestServer) handler(resp http.ResponseWriter, req *http.Request) {
 	apiIndex := strings.Index(req.URL.Path, "/api/")
 	if req.Method == "POST" && apiIndex == 0 {
 
-		// Reconnect if necessary
-		if !server.getSession().Connected {
-			if err := server.tryConnectNode(); err != nil {
-				log.Print(err)
-				if json, jsonErr := json.Marshal(err); jsonErr == nil {
-					resp.Write(json)
-				}
-				return
-			}
+		path := req.URL.Path[5:]
+		requestId := server.nextRequestId()
+
+		// Reconnect if necessary, such as after a cancelled request closed the connection.
+		// Only this session is affected, so the rest of the pool is left alone.
+		session := server.getSession()
+		if reconnected, err := session.ReconnectIfDisconnected(); err != nil {
+			writeError(resp, path, requestId, err)
+			return
+		} else if reconnected {
 			log.Print("Reconnected successfully to node")
 		}
 
-		path := req.URL.Path[5:]
-		requestMessage := "nano.api.req_" + path
-		responseMessage := "nano.api.res_" + path
-
-		// Get protobuf message types by name
-		msgType := proto.MessageType(requestMessage)
-		responseMsgType := proto.MessageType(responseMessage)
-		if msgType == nil || responseMsgType == nil {
-			responseError := &nano_client.Error{
-				Code:     1,
-				Category: "Marshalling",
-				Message:  "Could not find protobuffer message types for " + path,
-			}
-			if json, jsonErr := json.Marshal(responseError); jsonErr == nil {
-				resp.Write(json)
-			}
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			writeError(resp, path, requestId, &nano_client.Error{Code: 1, Message: err.Error(), Category: "Network"})
 			return
 		}
 
-		// Create protobuf message instances
-		new := reflect.New(msgType.Elem())
-		protomsg := new.Interface().(proto.Message)
-		new = reflect.New(responseMsgType.Elem())
-		protoresponse := new.Interface().(proto.Message)
-
-		// Unmarshall the JSON request into the protobuf request message
-		if err := jsonpb.Unmarshal(req.Body, protomsg); err != nil {
-			log.Print(err)
-			if json, jsonErr := json.Marshal(err); jsonErr == nil {
-				resp.Write(json)
-			}
-		} else {
-			// Request and write result as JSON
-			if err := server.getSession().Request(protomsg, protoresponse); err != nil {
-				log.Print(err)
-				if json, jsonErr := json.Marshal(err); jsonErr == nil {
-					resp.Write(json)
-				}
+		// Request and write result as JSON
+		// The node request is cancelled if the client disconnects
+		ctx := req.Context()
+		if seconds, ok := server.conf.Timeouts[path]; ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(seconds)*time.Second)
+			defer cancel()
+		}
+		if server.conf.Stream {
+			resp.Header().Set("Content-Type", "application/json")
+			out := &_FlushWriter{resp: resp}
+			buffered := bufio.NewWriterSize(out, 32*1024)
+			err := session.RequestJSONStream(ctx, path, body, buffered)
+			if err == nil {
+				buffered.Flush()
+			} else if out.written {
+				// The response is partially written and can't be replaced with an error
+				log.Printf("%s %s: %v", requestId, path, err)
 			} else {
-				m := &jsonpb.Marshaler{EmitDefaults: true}
-				m.Marshal(resp, protoresponse)
+				writeError(resp, path, requestId, err)
 			}
+		} else if result, err := session.RequestJSONContext(ctx, path, body); err != nil {
+			writeError(resp, path, requestId, err)
+		} else {
+			resp.Write(result)
 		}
+	} else if req.Method == "GET" && strings.Index(req.URL.Path, "/api/schema") == 0 {
+		server.schemaHandler(resp, req)
 	} else {
-		resp.Write([]byte("Invalid request method. Use POST."))
+		writeError(resp, strings.TrimPrefix(req.URL.Path, "/api/"), server.nextRequestId(),
+			&nano_client.Error{Code: 1, Message: "Invalid request method. Use POST.", Category: "Validation"})
 	}
 }
 
-// Start REST server
+// Returns the id of a new request
+func (server *RestServer) nextRequestId() string {
+	return strconv.FormatInt(atomic.AddInt64(&server.nextrequest, 1), 10)
+}
+
+// _FlushWriter sends each write to the client immediately, so streamed responses
+// aren't held back by the http package's buffering
+type _FlushWriter struct {
+	resp    http.ResponseWriter
+	written bool
+}
+
+func (w *_FlushWriter) Write(p []byte) (int, error) {
+	w.written = true
+	n, err := w.resp.Write(p)
+	if flusher, ok := w.resp.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
+
+// Logs the error and writes it as an _ErrorResponse. The type is the one declared by the
+// node if it responded, and otherwise the requested type given by path.
+func writeError(resp http.ResponseWriter, path string, requestId string, err *nano_client.Error) {
+	log.Printf("%s %s: %v", requestId, path, err)
+	responseType := path
+	if err.ResponseType != "" {
+		responseType = strings.ToLower(err.ResponseType)
+	}
+	errorResponse := _ErrorResponse{
+		Type:      responseType,
+		RequestId: requestId,
+		Error:     _ErrorResponseDetails{err.Code, err.Message, err.Category, err.Raw},
+	}
+	if json, jsonErr := json.Marshal(errorResponse); jsonErr == nil {
+		resp.Write(json)
+	}
+}
+
+// Start REST server. The config path is taken from the -config flag, or else
+// the NANO_CONFIG environment variable.
 func main() {
+	configPath := flag.String("config", os.Getenv("NANO_CONFIG"), "Config file, directory of config files, URL or - for stdin")
+	flag.Parse()
+
 	s := &RestServer{}
-	s.listen()
+	s.listen(*configPath)
 }
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"nano_api"
+	"nano_client"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+// Serves account_pending requests like a node would, returning a block for each account
+// with the requested count as its amount. The account "invalid" is answered with an error.
+// If closing is set, each connection is closed after its first response.
+func serveMockNode(t *testing.T, closing bool) string {
+	path := filepath.Join(t.TempDir(), "node")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			go serveMockConnection(conn, closing)
+		}
+	}()
+	return path
+}
+
+func serveMockConnection(conn net.Conn, closing bool) {
+	for {
+		var preamble [4]byte
+		if _, err := io.ReadFull(conn, preamble[:]); err != nil {
+			return
+		}
+		if _, err := readMockFrame(conn); err != nil {
+			return
+		}
+		body, err := readMockFrame(conn)
+		if err != nil {
+			return
+		}
+		request := &nano_api.ReqAccountPending{}
+		if err := proto.Unmarshal(body, request); err != nil {
+			return
+		}
+
+		header := &nano_api.Response{Type: nano_api.RequestType_ACCOUNT_PENDING}
+		response := &nano_api.ResAccountPending{}
+		for _, account := range request.Accounts {
+			if account == "invalid" {
+				header.ErrorCode = 2
+				header.ErrorMessage = "Invalid account"
+				header.ErrorCategory = "error_common"
+				response.Reset()
+				break
+			}
+			response.Pending = append(response.Pending, &nano_api.AccountPending{
+				Account: account,
+				BlockInfo: []*nano_api.AccountPendingBlockInfo{
+					{Hash: "hash_" + account, Amount: strconv.FormatUint(request.Count, 10)},
+				},
+			})
+		}
+		headerData, _ := proto.Marshal(header)
+		bodyData, _ := proto.Marshal(response)
+		out := []byte{nano_client.PROTOCOL_PREAMBLE_LEAD, nano_client.PROTOCOL_ENCODING,
+			byte(nano_api.APIVersion_VERSION_MAJOR), byte(nano_api.APIVersion_VERSION_MINOR)}
+		out = append(out, mockFrame(headerData)...)
+		conn.Write(append(out, mockFrame(bodyData)...))
+		if closing {
+			conn.Close()
+			return
+		}
+	}
+}
+
+func readMockFrame(r io.Reader) ([]byte, error) {
+	var bufLen [4]byte
+	if _, err := io.ReadFull(r, bufLen[:]); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(bufLen[:]))
+	_, err := io.ReadFull(r, data)
+	return data, err
+}
+
+func mockFrame(data []byte) []byte {
+	frame := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	return append(frame, data...)
+}
+
+// Starts a REST server connected to a mock node, returning its URL
+func startRestServer(t *testing.T, stream bool, closing bool) string {
+	server := &RestServer{conf: &_Conf{
+		Node:   _ConfNode{Connection: nano_client.UnixAddr(serveMockNode(t, closing)), Poolsize: 2},
+		Stream: stream,
+	}}
+	if err := server.tryConnectNode(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.shutdown() })
+	httpServer := httptest.NewServer(http.HandlerFunc(server.handler))
+	t.Cleanup(httpServer.Close)
+	return httpServer.URL
+}
+
+func post(t *testing.T, url string, body string) []byte {
+	resp, err := http.Post(url, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestAccountPending(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		url := startRestServer(t, stream, false)
+
+		// Each request goes to the next session of the pool
+		for i := 0; i < 3; i++ {
+			data := post(t, url+"/api/account_pending", `{"accounts": ["xrb_1", "xrb_2"], "count": 5}`)
+			response := &nano_api.ResAccountPending{}
+			if err := jsonpb.Unmarshal(bytes.NewReader(data), response); err != nil {
+				t.Fatalf("stream %v: invalid response %s: %v", stream, data, err)
+			}
+			if len(response.Pending) != 2 {
+				t.Fatalf("stream %v: expected 2 accounts, got %s", stream, data)
+			}
+			for j, account := range []string{"xrb_1", "xrb_2"} {
+				pending := response.Pending[j]
+				if pending.Account != account || len(pending.BlockInfo) != 1 ||
+					pending.BlockInfo[0].Hash != "hash_"+account || pending.BlockInfo[0].Amount != "5" {
+					t.Errorf("stream %v: unexpected pending blocks for %s: %s", stream, account, data)
+				}
+			}
+		}
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	tests := []struct {
+		path     string
+		body     string
+		code     int
+		category string
+	}{
+		// Node errors keep their code, message and category
+		{"account_pending", `{"accounts": ["invalid"]}`, 2, "error_common"},
+		{"account_pending", `{"accounts": 1}`, 1, "Marshalling"},
+		{"no_such_type", `{}`, 1, "Marshalling"},
+	}
+	for _, stream := range []bool{false, true} {
+		url := startRestServer(t, stream, false)
+		for _, test := range tests {
+			data := post(t, url+"/api/"+test.path, test.body)
+			var response _ErrorResponse
+			if err := json.Unmarshal(data, &response); err != nil {
+				t.Fatalf("%s %s: invalid error response %s: %v", test.path, test.body, data, err)
+			}
+			if response.Type != test.path || response.RequestId == "" ||
+				response.Error.Code != test.code || response.Error.Category != test.category {
+				t.Errorf("stream %v: %s %s: unexpected error response %s", stream, test.path, test.body, data)
+			}
+		}
+	}
+}
+
+func TestRequestErrorResponses(t *testing.T) {
+	url := startRestServer(t, false, false)
+	tests := []struct {
+		method string
+		path   string
+		status int
+		typ    string
+	}{
+		{"GET", "/api/account_pending", http.StatusOK, "account_pending"},
+		{"PUT", "/api/account_pending", http.StatusOK, "account_pending"},
+		{"GET", "/api/schema/no_such_type", http.StatusNotFound, "no_such_type"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, url+test.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		var response _ErrorResponse
+		if err := json.Unmarshal(data, &response); err != nil {
+			t.Errorf("%s %s: invalid error response %s: %v", test.method, test.path, data, err)
+			continue
+		}
+		if resp.StatusCode != test.status || response.Type != test.typ || response.RequestId == "" ||
+			response.Error.Category != "Validation" {
+			t.Errorf("%s %s: unexpected error response %d %s", test.method, test.path, resp.StatusCode, data)
+		}
+	}
+}
+
+func TestReconnectConcurrently(t *testing.T) {
+	// Requests share the pooled sessions, whose connections the node keeps closing
+	url := startRestServer(t, false, true)
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data := post(t, url+"/api/account_pending", `{"accounts": ["xrb_1"]}`)
+			response := &nano_api.ResAccountPending{}
+			if jsonpb.Unmarshal(bytes.NewReader(data), response) == nil && len(response.Pending) == 1 {
+				return
+			}
+			var errorResponse _ErrorResponse
+			if err := json.Unmarshal(data, &errorResponse); err != nil || errorResponse.Error.Category == "" {
+				t.Errorf("unexpected response %s", data)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// A session whose connection the node closed fails one request, and is reconnected
+	// by the next, so at least one of the two requests sent to each session succeeds
+	succeeded := 0
+	for i := 0; i < 4; i++ {
+		data := post(t, url+"/api/account_pending", `{"accounts": ["xrb_1"]}`)
+		response := &nano_api.ResAccountPending{}
+		if jsonpb.Unmarshal(bytes.NewReader(data), response) == nil && len(response.Pending) == 1 {
+			succeeded++
+		}
+	}
+	if succeeded < 2 {
+		t.Errorf("expected the sessions to be reconnected, but only %d of 4 requests succeeded", succeeded)
+	}
+}
+
+func TestErrorResponseType(t *testing.T) {
+	tests := []struct {
+		responseType string
+		expected     string
+	}{
+		{"ACCOUNT_PENDING", "account_pending"},
+		// No response header was received
+		{"", "account_pendng"},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		err := &nano_client.Error{Code: 2, Message: "error", Category: "error_common", ResponseType: test.responseType}
+		writeError(recorder, "account_pendng", "1", err)
+		var response _ErrorResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Type != test.expected {
+			t.Errorf("%q: expected type %q, got %q", test.responseType, test.expected, response.Type)
+		}
+	}
+}
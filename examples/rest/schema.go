@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"nano_api"
+	"nano_client"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// _SchemaField describes a single field of a protobuf message as seen in JSON
+type _SchemaField struct {
+	Name     string         `json:"name"`
+	Type     string         `json:"type"`
+	Repeated bool           `json:"repeated,omitempty"`
+	Fields   []_SchemaField `json:"fields,omitempty"`
+}
+
+// _Schema describes the request and response messages of a request type
+type _Schema struct {
+	Request  []_SchemaField `json:"request"`
+	Response []_SchemaField `json:"response"`
+}
+
+// Schema handler. GET /api/schema returns the schema of all request types, while
+// GET /api/schema/<type> returns the schema of a single request type, such as account_pending.
+func (server *RestServer) schemaHandler(resp http.ResponseWriter, req *http.Request) {
+	schemas := make(map[string]_Schema)
+	for _, name := range nano_api.RequestType_name {
+		path := strings.ToLower(name)
+		msgType := proto.MessageType("nano.api.req_" + path)
+		responseMsgType := proto.MessageType("nano.api.res_" + path)
+		if msgType != nil && responseMsgType != nil {
+			schemas[path] = _Schema{
+				Request:  describeMessage(msgType),
+				Response: describeMessage(responseMsgType),
+			}
+		}
+	}
+
+	var result interface{} = schemas
+	if path := strings.TrimPrefix(req.URL.Path, "/api/schema/"); path != req.URL.Path {
+		schema, ok := schemas[path]
+		if !ok {
+			resp.WriteHeader(http.StatusNotFound)
+			writeError(resp, path, server.nextRequestId(),
+				&nano_client.Error{Code: 1, Message: "Unknown request type " + path, Category: "Validation"})
+			return
+		}
+		result = schema
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	if json, jsonErr := json.Marshal(result); jsonErr == nil {
+		resp.Write(json)
+	}
+}
+
+// Lists the fields of a generated protobuf message type by reflecting over its protobuf struct tags
+func describeMessage(msgType reflect.Type) []_SchemaField {
+	fields := make([]_SchemaField, 0)
+	for i := 0; i < msgType.Elem().NumField(); i++ {
+		field := msgType.Elem().Field(i)
+		tag := field.Tag.Get("protobuf")
+		if tag == "" {
+			continue
+		}
+		props := &proto.Properties{}
+		props.Parse(tag)
+
+		schemaField := _SchemaField{Name: props.JSONName, Repeated: props.Repeated}
+		if schemaField.Name == "" {
+			schemaField.Name = props.OrigName
+		}
+		fieldType := field.Type
+		if props.Repeated && fieldType.Kind() == reflect.Slice {
+			fieldType = fieldType.Elem()
+		}
+		if msg, ok := reflect.Zero(fieldType).Interface().(proto.Message); ok {
+			schemaField.Type = proto.MessageName(msg)
+			// Well known types, such as wrappers, are rendered as scalars in JSON
+			if !strings.HasPrefix(schemaField.Type, "google.protobuf.") {
+				schemaField.Fields = describeMessage(fieldType)
+			}
+		} else {
+			schemaField.Type = fieldType.String()
+		}
+		fields = append(fields, schemaField)
+	}
+	return fields
+}
@@ -6,6 +6,7 @@ import (
 	"nano_api"
 	"nano_client"
 	"os"
+	"time"
 
 	"github.com/golang/protobuf/ptypes/wrappers"
 )
@@ -14,18 +15,21 @@ import (
 // Program argument is a connection string such as local:///tmp/nano
 // for unix domain sockets and tcp://localhost:7077 for tcp sockets.
 func main() {
-	connectionString := "local:///tmp/nano"
+	connectionString := nano_client.UnixAddr("/tmp/nano")
 	if len(os.Args) > 1 {
 		connectionString = os.Args[1]
 	}
 
 	session := &nano_client.Session{}
-	session.TimeoutConnection = 2
+	session.ConnTimeout = 2 * time.Second
 
-	if session.Connect(connectionString) != nil {
-		log.Println(session.LastError.Error())
+	if err := session.Connect(connectionString); err != nil {
+		log.Println(err.Error())
 	} else {
-		defer session.Close()
+		// Any Requester can be used, such as a Breaker wrapping the session
+		var requester nano_client.Requester = session
+		defer requester.Close()
+
 		pending := &nano_api.ReqAccountPending{
 			Count:     10,
 			Source:    true,
@@ -36,7 +40,7 @@ func main() {
 		}
 		result := &nano_api.ResAccountPending{}
 
-		err := session.Request(pending, result)
+		err := requester.Request(pending, result)
 		if err != nil {
 			fmt.Println(err.Error())
 		} else {
@@ -0,0 +1,114 @@
+package nano_api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// Protobuf files registered by this package
+var protoFiles = []string{"core.proto", "accounts.proto", "util.proto"}
+
+// Request message names mapped to their request types, built once by Init
+var (
+	initOnce   sync.Once
+	initErr    error
+	typeLookup map[string]RequestType
+)
+
+// Init builds the registry mapping request messages to request types, and validates
+// the naming standard like ValidateTypeMapping. Call this at startup so that
+// misconfigurations surface early and the first request doesn't pay the lookup cost.
+// Init is threadsafe; the work is only done once and later calls return the same result.
+func Init() error {
+	initOnce.Do(func() {
+		typeLookup, initErr = buildTypeLookup()
+	})
+	return initErr
+}
+
+// RequestTypeForMessage returns the request type for a request message name, such as
+// nano.api.req_account_pending. The name is uppercased without the req_ prefix, as
+// required by the RequestType naming standard. RequestType_INVALID is returned if
+// there's no matching request type.
+func RequestTypeForMessage(messageName string) RequestType {
+	if Init() == nil {
+		if requestType, ok := typeLookup[messageName]; ok {
+			return requestType
+		}
+	}
+	return requestTypeForName(messageName)
+}
+
+// RequestTypeForResponse returns the request type for a response message name, such as
+// nano.api.res_account_pending. RequestType_INVALID is returned if there's no matching
+// request type.
+func RequestTypeForResponse(messageName string) RequestType {
+	return RequestTypeForMessage(strings.Replace(messageName, "nano.api.res_", "nano.api.req_", 1))
+}
+
+// ValidateTypeMapping checks that every req_ message maps to a valid RequestType.
+// This catches protobuf changes which break the naming standard, which would
+// otherwise silently map requests to RequestType_INVALID.
+func ValidateTypeMapping() error {
+	_, err := buildTypeLookup()
+	return err
+}
+
+// Maps a request message name to its request type by the naming standard
+func requestTypeForName(messageName string) RequestType {
+	requestType := strings.ToUpper(strings.Replace(messageName, "nano.api.req_", "", 1))
+	return RequestType(RequestType_value[requestType])
+}
+
+// Maps every req_ message in the registered protobuf files to its request type, failing
+// if a message has no matching request type
+func buildTypeLookup() (map[string]RequestType, error) {
+	lookup := make(map[string]RequestType)
+	for _, file := range protoFiles {
+		fileDescriptor, err := decodeFileDescriptor(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range fileDescriptor.GetMessageType() {
+			if !strings.HasPrefix(msg.GetName(), "req_") {
+				continue
+			}
+			messageName := fileDescriptor.GetPackage() + "." + msg.GetName()
+			requestType := requestTypeForName(messageName)
+			if requestType == RequestType_INVALID {
+				return nil, fmt.Errorf("%s: no request type found for message %s", file, messageName)
+			}
+			lookup[messageName] = requestType
+		}
+	}
+	return lookup, nil
+}
+
+// Decodes the gzipped file descriptor registered for the given protobuf file
+func decodeFileDescriptor(file string) (*descriptor.FileDescriptorProto, error) {
+	gz := proto.FileDescriptor(file)
+	if gz == nil {
+		return nil, fmt.Errorf("%s: file descriptor not registered", file)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(gz))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	fileDescriptor := &descriptor.FileDescriptorProto{}
+	if err = proto.Unmarshal(data, fileDescriptor); err != nil {
+		return nil, err
+	}
+	return fileDescriptor, nil
+}
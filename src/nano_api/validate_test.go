@@ -0,0 +1,29 @@
+package nano_api
+
+import (
+	"testing"
+)
+
+func TestValidateTypeMapping(t *testing.T) {
+	if err := ValidateTypeMapping(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequestTypeForMessage(t *testing.T) {
+	tests := []struct {
+		messageName string
+		requestType RequestType
+	}{
+		{"nano.api.req_ping", RequestType_PING},
+		{"nano.api.req_account_pending", RequestType_ACCOUNT_PENDING},
+		{"nano.api.req_address_valid", RequestType_ADDRESS_VALID},
+		{"nano.api.req_unknown", RequestType_INVALID},
+		{"nano.api.res_ping", RequestType_INVALID},
+	}
+	for _, test := range tests {
+		if requestType := RequestTypeForMessage(test.messageName); requestType != test.requestType {
+			t.Errorf("%s: expected %v, got %v", test.messageName, test.requestType, requestType)
+		}
+	}
+}
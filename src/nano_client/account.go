@@ -0,0 +1,102 @@
+package nano_client
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"golang.org/x/crypto/blake2b"
+)
+
+// The base32 alphabet used by Nano account addresses
+const accountAlphabet = "13456789abcdefghijkmnopqrstuwxyz"
+
+// NormalizeAccount returns the account address with the canonical nano_ prefix in place
+// of the legacy xrb_ prefix. Both prefixes denote the same account. Other strings are
+// returned unchanged.
+func NormalizeAccount(addr string) string {
+	if strings.HasPrefix(addr, "xrb_") {
+		return "nano_" + addr[4:]
+	}
+	return addr
+}
+
+// ValidateAccount checks that addr is a well-formed Nano account address with an xrb_ or
+// nano_ prefix and a valid checksum. This catches typos before a request is sent.
+// Returns an Error with Category "Validation" if the address is invalid.
+func ValidateAccount(addr string) *Error {
+	var encoded string
+	if strings.HasPrefix(addr, "xrb_") {
+		encoded = addr[4:]
+	} else if strings.HasPrefix(addr, "nano_") {
+		encoded = addr[5:]
+	} else {
+		return newError("Account must start with xrb_ or nano_: "+addr, "Validation")
+	}
+	if len(encoded) != 60 {
+		return newError("Account has an invalid length: "+addr, "Validation")
+	}
+
+	// Decode the 4 padding bits, the 256 bit public key and the 40 bit checksum
+	var decoded [38]byte
+	var bits uint
+	var acc uint64
+	pos := 0
+	for _, c := range encoded {
+		value := strings.IndexRune(accountAlphabet, c)
+		if value < 0 {
+			return newError("Account contains invalid characters: "+addr, "Validation")
+		}
+		acc = acc<<5 | uint64(value)
+		bits += 5
+		if bits >= 8 {
+			bits -= 8
+			decoded[pos] = byte(acc >> bits)
+			pos++
+		}
+	}
+	if decoded[0]&0xf0 != 0 {
+		return newError("Account has invalid padding: "+addr, "Validation")
+	}
+
+	// Shift out the padding bits
+	var publicKey [32]byte
+	for i := range publicKey {
+		publicKey[i] = decoded[i]<<4 | decoded[i+1]>>4
+	}
+	var checksum [5]byte
+	for i := range checksum {
+		checksum[i] = decoded[i+32]<<4 | decoded[i+33]>>4
+	}
+	checksum[4] |= byte(acc & 0x0f)
+
+	// The checksum is the 5 byte blake2b hash of the public key in reverse byte order
+	hash, _ := blake2b.New(5, nil)
+	hash.Write(publicKey[:])
+	expected := hash.Sum(nil)
+	for i, j := 0, len(expected)-1; i < j; i, j = i+1, j-1 {
+		expected[i], expected[j] = expected[j], expected[i]
+	}
+	if !bytes.Equal(expected, checksum[:]) {
+		return newError("Account has an invalid checksum: "+addr, "Validation")
+	}
+	return nil
+}
+
+// The largest amount in raw units, which is the total supply of 2^128-1 raw
+var maxRaw = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))
+
+// PendingThreshold returns a threshold for ReqAccountPending, given a decimal amount in
+// raw units. Returns an Error with Category "Validation" if raw is not a non-negative
+// integer within the supply.
+func PendingThreshold(raw string) (*wrappers.StringValue, *Error) {
+	amount, ok := new(big.Int).SetString(raw, 10)
+	if !ok || strings.HasPrefix(raw, "+") {
+		return nil, newError("Threshold must be an integer amount in raw: "+raw, "Validation")
+	}
+	if amount.Sign() < 0 || amount.Cmp(maxRaw) > 0 {
+		return nil, newError("Threshold is out of range: "+raw, "Validation")
+	}
+	return &wrappers.StringValue{Value: raw}, nil
+}
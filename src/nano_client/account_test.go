@@ -0,0 +1,66 @@
+package nano_client
+
+import "testing"
+
+func TestValidateAccount(t *testing.T) {
+	tests := []struct {
+		addr  string
+		valid bool
+	}{
+		// Genesis
+		{"xrb_3t6k35gi95xu6tergt6p69ck76ogmitsa8mnijtpxm9fkcm736xtoncuohr3", true},
+		{"nano_3t6k35gi95xu6tergt6p69ck76ogmitsa8mnijtpxm9fkcm736xtoncuohr3", true},
+		// Burn
+		{"xrb_1111111111111111111111111111111111111111111111111111hifc8npp", true},
+		// Checksum
+		{"xrb_3t6k35gi95xu6tergt6p69ck76ogmitsa8mnijtpxm9fkcm736xtoncuohr4", false},
+		{"xrb_1111111111111111111111111111111111111111111111111111hifc8npq", false},
+		// Padding bits
+		{"xrb_4111111111111111111111111111111111111111111111111111hifc8npp", false},
+		// Prefix, length and alphabet
+		{"xrc_3t6k35gi95xu6tergt6p69ck76ogmitsa8mnijtpxm9fkcm736xtoncuohr3", false},
+		{"3t6k35gi95xu6tergt6p69ck76ogmitsa8mnijtpxm9fkcm736xtoncuohr3", false},
+		{"xrb_3t6k35gi95xu6tergt6p69ck76ogmitsa8mnijtpxm9fkcm736xtoncuohr", false},
+		{"xrb_3t6k35gi95xu6tergt6p69ck76ogmitsa8mnijtpxm9fkcm736xtoncuohr30", false},
+		{"xrb_0t6k35gi95xu6tergt6p69ck76ogmitsa8mnijtpxm9fkcm736xtoncuohr3", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		err := ValidateAccount(test.addr)
+		if test.valid && err != nil {
+			t.Errorf("%q: unexpected error: %v", test.addr, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("%q: expected an error", test.addr)
+		} else if err != nil && err.Category != "Validation" {
+			t.Errorf("%q: expected a Validation error, got %v", test.addr, err)
+		}
+	}
+}
+
+func TestPendingThreshold(t *testing.T) {
+	tests := []struct {
+		raw   string
+		valid bool
+	}{
+		{"0", true},
+		{"1000000000000000000000000", true},
+		// The total supply of 2^128-1 raw
+		{"340282366920938463463374607431768211455", true},
+		{"340282366920938463463374607431768211456", false},
+		{"+5", false},
+		{"-1", false},
+		{"1e5", false},
+		{"1.5", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		threshold, err := PendingThreshold(test.raw)
+		if test.valid && (err != nil || threshold.Value != test.raw) {
+			t.Errorf("%q: unexpected result %v, error %v", test.raw, threshold, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("%q: expected an error", test.raw)
+		} else if err != nil && err.Category != "Validation" {
+			t.Errorf("%q: expected a Validation error, got %v", test.raw, err)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package nano_client
+
+import (
+	"net"
+	"net/url"
+	"path/filepath"
+	"strconv"
+)
+
+// TCPAddr returns a connection string for a node listening on the given tcp host and port,
+// such as tcp://localhost:7077. IPv6 hosts are bracketed as required.
+func TCPAddr(host string, port int) string {
+	return "tcp://" + net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// UnixAddr returns a connection string for a node listening on the given domain socket
+// file, such as local:///tmp/nano. Relative paths are made absolute, as the path of a
+// local connection string must be absolute.
+func UnixAddr(path string) string {
+	if absPath, err := filepath.Abs(path); err == nil {
+		path = absPath
+	}
+	return (&url.URL{Scheme: "local", Path: path}).String()
+}
@@ -0,0 +1,41 @@
+package nano_client
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// BoundSession sends requests through a Session with a fixed timeout. It is
+// created by Session#WithTimeout.
+type BoundSession struct {
+	session *Session
+	timeout time.Duration
+}
+
+// WithTimeout returns a requester which sends each request using Session#RequestContext,
+// with a context that times out after d. For example:
+// session.WithTimeout(5*time.Second).Request(req, res)
+func (s *Session) WithTimeout(d time.Duration) *BoundSession {
+	return &BoundSession{session: s, timeout: d}
+}
+
+// Request sends the request, which fails with a Context error if it's not done in time.
+// This method is threadsafe.
+func (b *BoundSession) Request(request proto.Message, response proto.Message) *Error {
+	return b.RequestContext(context.Background(), request, response)
+}
+
+// RequestContext works like Request, but the request is also aborted when ctx is cancelled.
+// This method is threadsafe.
+func (b *BoundSession) RequestContext(ctx context.Context, request proto.Message, response proto.Message) *Error {
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+	return b.session.RequestContext(ctx, request, response)
+}
+
+// Close closes the session
+func (b *BoundSession) Close() *Error {
+	return b.session.Close()
+}
@@ -0,0 +1,91 @@
+package nano_client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Breaker is a circuit breaker around a Requester. After a number of consecutive
+// connection failures, the breaker opens and requests fail immediately with a Breaker
+// error until the cooldown has passed. A single request is then let through to probe
+// recovery; if it succeeds the breaker closes, if it fails to connect it opens again.
+// Other errors, such as node errors and cancelled requests, say nothing about the
+// connection and don't count as failures.
+type Breaker struct {
+	mutex     sync.Mutex
+	requester Requester
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+	probing   bool
+}
+
+// NewBreaker returns a circuit breaker which opens after threshold consecutive
+// connection failures and stays open for the cooldown duration. Panics if threshold
+// is not positive.
+func NewBreaker(r Requester, threshold int, cooldown time.Duration) *Breaker {
+	if threshold <= 0 {
+		panic("NewBreaker requires a positive threshold")
+	}
+	return &Breaker{requester: r, threshold: threshold, cooldown: cooldown}
+}
+
+// Request sends the request through the wrapped Requester unless the breaker is open.
+// This method is threadsafe.
+func (b *Breaker) Request(request proto.Message, response proto.Message) *Error {
+	return b.do(func() *Error {
+		return b.requester.Request(request, response)
+	})
+}
+
+// RequestContext works like Request, but uses RequestContext of the wrapped Requester.
+// This method is threadsafe.
+func (b *Breaker) RequestContext(ctx context.Context, request proto.Message, response proto.Message) *Error {
+	return b.do(func() *Error {
+		return b.requester.RequestContext(ctx, request, response)
+	})
+}
+
+// Close closes the wrapped Requester
+func (b *Breaker) Close() *Error {
+	return b.requester.Close()
+}
+
+// Calls send unless the breaker is open, and records the outcome
+func (b *Breaker) do(send func() *Error) *Error {
+	probe := false
+	b.mutex.Lock()
+	if b.failures >= b.threshold {
+		// Half-open after the cooldown, allowing a single probing request
+		if b.probing || time.Since(b.openedAt) < b.cooldown {
+			b.mutex.Unlock()
+			return newError("Circuit breaker is open", "Breaker")
+		}
+		b.probing = true
+		probe = true
+	}
+	b.mutex.Unlock()
+
+	err := send()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if probe {
+		// Requests sent before the breaker opened may complete while probing,
+		// so only the probe itself ends the half-open state
+		b.probing = false
+	}
+	if err == nil {
+		b.failures = 0
+	} else if isConnectionFailure(err) {
+		b.failures++
+		if b.failures >= b.threshold {
+			b.openedAt = time.Now()
+		}
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package nano_client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// A Requester whose requests block until the test replies to them through calls
+type blockingRequester struct {
+	calls chan chan *Error
+}
+
+func newBlockingRequester() *blockingRequester {
+	return &blockingRequester{calls: make(chan chan *Error)}
+}
+
+func (r *blockingRequester) Request(request proto.Message, response proto.Message) *Error {
+	reply := make(chan *Error)
+	r.calls <- reply
+	return <-reply
+}
+
+func (r *blockingRequester) RequestContext(ctx context.Context, request proto.Message, response proto.Message) *Error {
+	return r.Request(request, response)
+}
+
+func (r *blockingRequester) Close() *Error {
+	return nil
+}
+
+// Sends a request through the breaker in the background, returning its error on the channel
+func requestAsync(b *Breaker) chan *Error {
+	result := make(chan *Error, 1)
+	go func() {
+		result <- b.Request(nil, nil)
+	}()
+	return result
+}
+
+// Sends a request through the breaker, replying err to it if it reaches the requester
+func requestWith(r *blockingRequester, b *Breaker, err *Error) *Error {
+	result := requestAsync(b)
+	select {
+	case reply := <-r.calls:
+		reply <- err
+	case err := <-result:
+		return err
+	}
+	return <-result
+}
+
+func TestBreakerIgnoresNodeErrors(t *testing.T) {
+	r := newBlockingRequester()
+	b := NewBreaker(r, 2, time.Hour)
+	nodeErr := &Error{Code: 4, Message: "bad", Category: "error_common"}
+	for i := 0; i < 3; i++ {
+		if err := requestWith(r, b, nodeErr); err != nodeErr {
+			t.Fatalf("request %d: expected the node error, got %v", i, err)
+		}
+	}
+}
+
+func TestBreakerOpensOnConnectionFailures(t *testing.T) {
+	r := newBlockingRequester()
+	b := NewBreaker(r, 2, time.Hour)
+	for i := 0; i < 2; i++ {
+		requestWith(r, b, newError("refused", "Connection"))
+	}
+	if err := requestWith(r, b, nil); err == nil || err.Category != "Breaker" {
+		t.Fatalf("expected a Breaker error, got %v", err)
+	}
+}
+
+func TestBreakerStaysHalfOpenUntilProbeCompletes(t *testing.T) {
+	r := newBlockingRequester()
+	b := NewBreaker(r, 1, 0)
+
+	// A request in flight while the breaker opens
+	inFlight := requestAsync(b)
+	inFlightReply := <-r.calls
+	requestWith(r, b, newError("refused", "Connection"))
+
+	// The probe is let through, and blocks
+	probe := requestAsync(b)
+	probeReply := <-r.calls
+
+	// The earlier request completing must not end the half-open state
+	inFlightReply <- newError("bad", "error_common")
+	<-inFlight
+	if err := requestWith(r, b, nil); err == nil || err.Category != "Breaker" {
+		t.Fatalf("expected a Breaker error while probing, got %v", err)
+	}
+
+	probeReply <- nil
+	if err := <-probe; err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	if err := requestWith(r, b, nil); err != nil {
+		t.Fatalf("expected the breaker to close after the probe, got %v", err)
+	}
+}
+
+func TestNewBreakerRejectsInvalidThreshold(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic")
+		}
+	}()
+	NewBreaker(newBlockingRequester(), 0, time.Second)
+}
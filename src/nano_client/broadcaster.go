@@ -0,0 +1,127 @@
+package nano_client
+
+import (
+	"context"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Broadcaster sends requests to several nodes, such as redundant nodes
+// serving read queries.
+type Broadcaster struct {
+	requesters []Requester
+}
+
+// NewBroadcaster returns a broadcaster sending requests to all the given requesters
+func NewBroadcaster(requesters ...Requester) *Broadcaster {
+	return &Broadcaster{requesters: requesters}
+}
+
+// RequestAny sends the request to all nodes concurrently and returns as soon as one
+// succeeds, storing its response in the response argument. If all requests fail, the
+// last error is returned.
+// The remaining requests are not cancelled, as an interrupted Session can't read the
+// pending response and must close its connection. They complete in the background and
+// their responses are discarded.
+func (b *Broadcaster) RequestAny(request proto.Message, response proto.Message) *Error {
+	return b.requestAny(context.Background(), request, response)
+}
+
+// Request is the same as RequestAny, which allows using a Broadcaster as a Requester
+func (b *Broadcaster) Request(request proto.Message, response proto.Message) *Error {
+	return b.requestAny(context.Background(), request, response)
+}
+
+// RequestContext works like RequestAny, but the requests are sent with RequestContext and
+// are bounded by the deadline of ctx, if any. If ctx is cancelled before any request
+// succeeds, a Context error is returned. As with RequestAny, the remaining requests are
+// not cancelled along with ctx, so the sessions keep their connections.
+func (b *Broadcaster) RequestContext(ctx context.Context, request proto.Message, response proto.Message) *Error {
+	return b.requestAny(ctx, request, response)
+}
+
+// Close closes all requesters, returning the last error, if any
+func (b *Broadcaster) Close() *Error {
+	var err *Error
+	for _, requester := range b.requesters {
+		if closeErr := requester.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Sends the request to all nodes and returns the first successful response
+func (b *Broadcaster) requestAny(ctx context.Context, request proto.Message, response proto.Message) *Error {
+	type result struct {
+		response proto.Message
+		err      *Error
+	}
+	if err := checkMessages(request, response); err != nil {
+		return err
+	}
+
+	results := make(chan result, len(b.requesters))
+	for _, requester := range b.requesters {
+		// Each request gets its own messages, as they may outlive this call
+		req, res := proto.Clone(request), proto.Clone(response)
+		res.Reset()
+		go func(requester Requester) {
+			requestCtx, cancel := detachContext(ctx)
+			defer cancel()
+			results <- result{res, requester.RequestContext(requestCtx, req, res)}
+		}(requester)
+	}
+
+	err := newError("No requesters", "Broadcaster")
+	for range b.requesters {
+		select {
+		case res := <-results:
+			if res.err == nil {
+				response.Reset()
+				proto.Merge(response, res.response)
+				return nil
+			}
+			err = res.err
+		case <-ctx.Done():
+			return wrapError(ctx.Err(), "Context")
+		}
+	}
+	return err
+}
+
+// Returns a context with the deadline of ctx, if any, which isn't cancelled along with ctx.
+// Cancelling a request would close the connection of its session.
+func detachContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(context.Background(), deadline)
+	}
+	return context.Background(), func() {}
+}
+
+// RequestAll sends the request to all nodes concurrently and waits for all of them to
+// complete. The responses argument must have one response message per requester, in the
+// order the requesters were passed to NewBroadcaster. The returned slice contains the
+// error, if any, of each request. Requests with a nil request or response message
+// aren't sent, and fail with a Marshalling error.
+func (b *Broadcaster) RequestAll(request proto.Message, responses []proto.Message) []*Error {
+	if len(responses) != len(b.requesters) {
+		panic("RequestAll requires one response per requester")
+	}
+
+	errs := make([]*Error, len(b.requesters))
+	var wg sync.WaitGroup
+	for i, requester := range b.requesters {
+		if errs[i] = checkMessages(request, responses[i]); errs[i] != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, requester Requester) {
+			defer wg.Done()
+			errs[i] = requester.Request(proto.Clone(request), responses[i])
+		}(i, requester)
+	}
+	wg.Wait()
+	return errs
+}
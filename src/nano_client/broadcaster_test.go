@@ -0,0 +1,28 @@
+package nano_client
+
+import (
+	"context"
+	"nano_api"
+	"testing"
+	"time"
+)
+
+func TestBroadcasterLeavesSlowRequestsRunning(t *testing.T) {
+	fast := newFakeNode(t, pingHandler).connect(t)
+	slow := newFakeNode(t, withFault(pingHandler, 0, 100*time.Millisecond)).connect(t)
+	b := NewBroadcaster(fast, slow)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	response := &nano_api.ResPing{}
+	if err := b.RequestContext(ctx, &nano_api.ReqPing{Id: 1}, response); err != nil || response.Id != 1 {
+		t.Fatalf("expected the fast response, got id %d, error %v", response.Id, err)
+	}
+	// Cancelling once the call returned must not abort the slow request
+	cancel()
+
+	// The slow request completes in the background, and its session stays usable
+	response = &nano_api.ResPing{}
+	if err := slow.Request(&nano_api.ReqPing{Id: 2}, response); err != nil || response.Id != 2 {
+		t.Fatalf("expected the slow session to stay connected, got id %d, error %v", response.Id, err)
+	}
+}
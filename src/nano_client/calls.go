@@ -0,0 +1,146 @@
+package nano_client
+
+import (
+	"context"
+	"nano_api"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+)
+
+// RequestResponse pairs a request message with the response message of the matching type.
+// This is implemented by the call types below, such as PingCall.
+type RequestResponse interface {
+	// The request message to send
+	Request() proto.Message
+	// The response message to unmarshal the result into
+	Response() proto.Message
+}
+
+// Do sends the request of the call and stores the result in the call's response.
+// Session#DefaultPendingCount and Session#DefaultPendingThreshold are applied to
+// account pending requests which leave those fields unset, and the accounts are
+// normalized if Session#NormalizeAccounts is set.
+// This method is threadsafe.
+func (s *Session) Do(call RequestResponse) *Error {
+	request := call.Request()
+	if pending, ok := request.(*nano_api.ReqAccountPending); ok {
+		request = s.withPendingDefaults(pending)
+	}
+	return s.Request(request, call.Response())
+}
+
+// Returns the request with the session's pending defaults applied to unset fields,
+// and the accounts normalized if enabled. The request is copied if changed, as it's
+// owned by the caller. A nil request is returned as is, to be rejected by the request.
+func (s *Session) withPendingDefaults(request *nano_api.ReqAccountPending) *nano_api.ReqAccountPending {
+	if request == nil {
+		return request
+	}
+	setCount := request.Count == 0 && s.DefaultPendingCount != 0
+	setThreshold := request.Threshold == nil && s.DefaultPendingThreshold != ""
+	normalize := false
+	if s.NormalizeAccounts {
+		for _, account := range request.Accounts {
+			normalize = normalize || NormalizeAccount(account) != account
+		}
+	}
+	if !setCount && !setThreshold && !normalize {
+		return request
+	}
+	request = proto.Clone(request).(*nano_api.ReqAccountPending)
+	if setCount {
+		request.Count = s.DefaultPendingCount
+	}
+	if setThreshold {
+		request.Threshold = &wrappers.StringValue{Value: s.DefaultPendingThreshold}
+	}
+	if normalize {
+		for i, account := range request.Accounts {
+			request.Accounts[i] = NormalizeAccount(account)
+		}
+	}
+	return request
+}
+
+// PingCall pairs ReqPing with ResPing. Res is created by Do if not set.
+type PingCall struct {
+	Req *nano_api.ReqPing
+	Res *nano_api.ResPing
+}
+
+func (c *PingCall) Request() proto.Message {
+	return c.Req
+}
+
+func (c *PingCall) Response() proto.Message {
+	if c.Res == nil {
+		c.Res = &nano_api.ResPing{}
+	}
+	return c.Res
+}
+
+// AccountPendingCall pairs ReqAccountPending with ResAccountPending. Res is created by Do if not set.
+type AccountPendingCall struct {
+	Req *nano_api.ReqAccountPending
+	Res *nano_api.ResAccountPending
+}
+
+func (c *AccountPendingCall) Request() proto.Message {
+	return c.Req
+}
+
+func (c *AccountPendingCall) Response() proto.Message {
+	if c.Res == nil {
+		c.Res = &nano_api.ResAccountPending{}
+	}
+	return c.Res
+}
+
+// AddressValidCall pairs ReqAddressValid with ResAddressValid. Res is created by Do if not set.
+type AddressValidCall struct {
+	Req *nano_api.ReqAddressValid
+	Res *nano_api.ResAddressValid
+}
+
+func (c *AddressValidCall) Request() proto.Message {
+	return c.Req
+}
+
+func (c *AddressValidCall) Response() proto.Message {
+	if c.Res == nil {
+		c.Res = &nano_api.ResAddressValid{}
+	}
+	return c.Res
+}
+
+// Result carries the response of a typed request method, such as Session#AccountPending,
+// along with information about the request.
+type Result[T proto.Message] struct {
+	Response T
+	// The response header sent by the node, or nil if none was received
+	Header *nano_api.Response
+	// True if the connection was redialed for the request, such as when it exceeded
+	// Session#MaxConnectionLifetime
+	Reconnected bool
+}
+
+// AccountPending sends the request, applying the session defaults and normalization
+// like Session#Do.
+// This method is threadsafe.
+func (s *Session) AccountPending(request *nano_api.ReqAccountPending) (Result[*nano_api.ResAccountPending], *Error) {
+	return requestResult(s, s.withPendingDefaults(request), &nano_api.ResAccountPending{})
+}
+
+// Sends the request like Session#Request and returns the response as a Result
+func requestResult[T proto.Message](s *Session, request proto.Message, response T) (Result[T], *Error) {
+	result := Result[T]{Response: response}
+	err := s.intercept(func(request proto.Message, response proto.Message) *Error {
+		c := &call{request: request, response: response}
+		err := s.send(context.Background(), c)
+		result.Header = c.header
+		result.Reconnected = c.reconnected
+		return err
+	})(request, response)
+	return result, err
+}
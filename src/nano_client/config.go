@@ -0,0 +1,126 @@
+package nano_client
+
+import (
+	"nano_api"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// SessionConfig is the configuration of a Session as a plain struct, which can be
+// stored as JSON. Durations are in nanoseconds, as encoded by encoding/json.
+type SessionConfig struct {
+	// Connection string, or comma separated list of connection strings
+	Connection            string        `json:"connection"`
+	Label                 string        `json:"label,omitempty"`
+	RWTimeout             time.Duration `json:"rwTimeout,omitempty"`
+	ConnTimeout           time.Duration `json:"connTimeout,omitempty"`
+	ProbeInterval         time.Duration `json:"probeInterval,omitempty"`
+	ProbeTimeout          time.Duration `json:"probeTimeout,omitempty"`
+	MaxConnectionLifetime time.Duration `json:"maxConnectionLifetime,omitempty"`
+	FallbackDelay         time.Duration `json:"fallbackDelay,omitempty"`
+	MaxRequestBytes       int           `json:"maxRequestBytes,omitempty"`
+	MaxResponseBytes      int           `json:"maxResponseBytes,omitempty"`
+	ReadAheadSize         int           `json:"readAheadSize,omitempty"`
+	ResponseBufferCap     int           `json:"responseBufferCap,omitempty"`
+	ReadBufferSize        int           `json:"readBufferSize,omitempty"`
+	WriteBufferSize       int           `json:"writeBufferSize,omitempty"`
+	WriteBuffering        bool          `json:"writeBuffering,omitempty"`
+	SkipSocketCheck       bool          `json:"skipSocketCheck,omitempty"`
+	VerifyOnConnect       bool          `json:"verifyOnConnect,omitempty"`
+	AllowNewerVersion     bool          `json:"allowNewerVersion,omitempty"`
+	ReturnPartialOnError  bool          `json:"returnPartialOnError,omitempty"`
+	EncodingByte          byte          `json:"encodingByte,omitempty"`
+	// Maximum requests per second by lowercase request type, such as account_pending
+	RateLimits              map[string]float64 `json:"rateLimits,omitempty"`
+	RateLimitWait           bool               `json:"rateLimitWait,omitempty"`
+	DefaultPendingCount     uint64             `json:"defaultPendingCount,omitempty"`
+	DefaultPendingThreshold string             `json:"defaultPendingThreshold,omitempty"`
+	NormalizeAccounts       bool               `json:"normalizeAccounts,omitempty"`
+}
+
+// Config returns the configuration of the session. Settings which can't be serialized,
+// such as Session#DialControl, Session#Logger and interceptors, are not included.
+func (s *Session) Config() SessionConfig {
+	config := SessionConfig{
+		Connection:              strings.Join(s.connStrings, ","),
+		Label:                   s.Label,
+		RWTimeout:               s.RWTimeout,
+		ConnTimeout:             s.ConnTimeout,
+		ProbeInterval:           s.ProbeInterval,
+		ProbeTimeout:            s.ProbeTimeout,
+		MaxConnectionLifetime:   s.MaxConnectionLifetime,
+		FallbackDelay:           s.FallbackDelay,
+		MaxRequestBytes:         s.MaxRequestBytes,
+		MaxResponseBytes:        s.MaxResponseBytes,
+		ReadAheadSize:           s.ReadAheadSize,
+		ResponseBufferCap:       s.ResponseBufferCap,
+		ReadBufferSize:          s.ReadBufferSize,
+		WriteBufferSize:         s.WriteBufferSize,
+		WriteBuffering:          s.writeBuffering,
+		SkipSocketCheck:         s.SkipSocketCheck,
+		VerifyOnConnect:         s.VerifyOnConnect,
+		AllowNewerVersion:       s.AllowNewerVersion,
+		ReturnPartialOnError:    s.ReturnPartialOnError,
+		EncodingByte:            s.EncodingByte,
+		RateLimitWait:           s.RateLimitWait,
+		DefaultPendingCount:     s.DefaultPendingCount,
+		DefaultPendingThreshold: s.DefaultPendingThreshold,
+		NormalizeAccounts:       s.NormalizeAccounts,
+	}
+	if len(s.RateLimits) > 0 {
+		config.RateLimits = make(map[string]float64)
+		for requestType, limit := range s.RateLimits {
+			config.RateLimits[strings.ToLower(requestType.String())] = float64(limit)
+		}
+	}
+	return config
+}
+
+// NewSessionFromConfig returns an unconnected session with the given configuration.
+// Session#Reconnect connects to the configured connection string, and verifies the
+// connection like Connect does if VerifyOnConnect is set.
+// Returns an Error with Category "Validation" if a rate limit names an unknown request type.
+func NewSessionFromConfig(config SessionConfig) (*Session, *Error) {
+	s := &Session{
+		Label:                   config.Label,
+		RWTimeout:               config.RWTimeout,
+		ConnTimeout:             config.ConnTimeout,
+		ProbeInterval:           config.ProbeInterval,
+		ProbeTimeout:            config.ProbeTimeout,
+		MaxConnectionLifetime:   config.MaxConnectionLifetime,
+		FallbackDelay:           config.FallbackDelay,
+		MaxRequestBytes:         config.MaxRequestBytes,
+		MaxResponseBytes:        config.MaxResponseBytes,
+		ReadAheadSize:           config.ReadAheadSize,
+		ResponseBufferCap:       config.ResponseBufferCap,
+		ReadBufferSize:          config.ReadBufferSize,
+		WriteBufferSize:         config.WriteBufferSize,
+		writeBuffering:          config.WriteBuffering,
+		SkipSocketCheck:         config.SkipSocketCheck,
+		VerifyOnConnect:         config.VerifyOnConnect,
+		AllowNewerVersion:       config.AllowNewerVersion,
+		ReturnPartialOnError:    config.ReturnPartialOnError,
+		EncodingByte:            config.EncodingByte,
+		RateLimitWait:           config.RateLimitWait,
+		DefaultPendingCount:     config.DefaultPendingCount,
+		DefaultPendingThreshold: config.DefaultPendingThreshold,
+		NormalizeAccounts:       config.NormalizeAccounts,
+	}
+	if config.Connection != "" {
+		s.connStrings = splitConnectionString(config.Connection)
+		s.connectionString = s.connStrings[0]
+	}
+	if len(config.RateLimits) > 0 {
+		s.RateLimits = make(map[nano_api.RequestType]rate.Limit)
+		for name, limit := range config.RateLimits {
+			requestType, ok := nano_api.RequestType_value[strings.ToUpper(name)]
+			if !ok {
+				return nil, newError("Unknown request type in rate limits: "+name, "Validation")
+			}
+			s.RateLimits[nano_api.RequestType(requestType)] = rate.Limit(limit)
+		}
+	}
+	return s, nil
+}
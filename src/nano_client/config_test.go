@@ -0,0 +1,38 @@
+package nano_client
+
+import (
+	"encoding/json"
+	"nano_api"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSessionFromConfigVerifiesOnReconnect(t *testing.T) {
+	var handshakes int32
+	node := newFakeNode(t, func(conn net.Conn, header *nano_api.Request, body []byte) {
+		atomic.AddInt32(&handshakes, 1)
+		pingHandler(conn, header, body)
+	})
+
+	// The configuration survives a JSON round trip
+	data, err := json.Marshal(SessionConfig{Connection: node.connectionString(), VerifyOnConnect: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config SessionConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	s, configErr := NewSessionFromConfig(config)
+	if configErr != nil {
+		t.Fatal(configErr)
+	}
+	if err := s.Reconnect(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if atomic.LoadInt32(&handshakes) != 1 || s.HandshakeDuration() == 0 {
+		t.Errorf("expected Reconnect to verify the connection, got %d handshakes", atomic.LoadInt32(&handshakes))
+	}
+}
@@ -0,0 +1,75 @@
+package nano_client
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// Byte counts for the lifetime of a session, across connections
+type byteTotals struct {
+	read    uint64
+	written uint64
+}
+
+// countingConn counts the bytes read from and written to a connection, adding
+// them to the session totals as well
+type countingConn struct {
+	// First for 64-bit alignment of atomic operations
+	read    uint64
+	written uint64
+	net.Conn
+	totals *byteTotals
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddUint64(&c.read, uint64(n))
+	atomic.AddUint64(&c.totals.read, uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddUint64(&c.written, uint64(n))
+	atomic.AddUint64(&c.totals.written, uint64(n))
+	return n, err
+}
+
+// Returns the current connection, which is kept apart from Session#connection so
+// it can be reached without the session mutex, such as for byte counts and by Close
+func (s *Session) countingConn() *countingConn {
+	c, _ := s.counting.Load().(*countingConn)
+	return c
+}
+
+// BytesRead returns the number of bytes read from the current connection
+func (s *Session) BytesRead() uint64 {
+	if c := s.countingConn(); c != nil {
+		return atomic.LoadUint64(&c.read)
+	}
+	return 0
+}
+
+// BytesWritten returns the number of bytes written to the current connection
+func (s *Session) BytesWritten() uint64 {
+	if c := s.countingConn(); c != nil {
+		return atomic.LoadUint64(&c.written)
+	}
+	return 0
+}
+
+// TotalBytesRead returns the number of bytes read from all connections of the session
+func (s *Session) TotalBytesRead() uint64 {
+	if c := s.countingConn(); c != nil {
+		return atomic.LoadUint64(&c.totals.read)
+	}
+	return 0
+}
+
+// TotalBytesWritten returns the number of bytes written to all connections of the session
+func (s *Session) TotalBytesWritten() uint64 {
+	if c := s.countingConn(); c != nil {
+		return atomic.LoadUint64(&c.totals.written)
+	}
+	return 0
+}
@@ -0,0 +1,53 @@
+package nano_client
+
+import (
+	"nano_api"
+	"sync"
+	"testing"
+)
+
+func TestByteCountsDuringReconnect(t *testing.T) {
+	node := newFakeNode(t, pingHandler)
+	s := node.connect(t)
+	if err := s.Request(&nano_api.ReqPing{}, &nano_api.ResPing{}); err != nil {
+		t.Fatal(err)
+	}
+	written := s.TotalBytesWritten()
+	if written == 0 || s.BytesWritten() != written || s.BytesRead() == 0 {
+		t.Fatalf("unexpected byte counts: read %d, written %d, total written %d",
+			s.BytesRead(), s.BytesWritten(), written)
+	}
+
+	// Counts are read without the session mutex, concurrently with redialing
+	started := make(chan struct{})
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		close(started)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				s.BytesRead()
+				s.BytesWritten()
+				s.TotalBytesRead()
+			}
+		}
+	}()
+	<-started
+	for i := 0; i < 100; i++ {
+		if err := s.Reconnect(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if s.BytesWritten() != 0 || s.TotalBytesWritten() != written {
+		t.Errorf("expected the totals to span connections: written %d, total written %d",
+			s.BytesWritten(), s.TotalBytesWritten())
+	}
+}
@@ -0,0 +1,59 @@
+package nano_client
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Failover sends requests to a primary node, retrying on a standby node if the
+// primary fails with a connection or network error. Errors reported by the node
+// are not retried. To avoid connect latency on failover, the standby should be a
+// connected Session, kept warm by setting Session#ProbeInterval.
+// As failed requests are sent again, only idempotent requests should be sent
+// through a Failover.
+type Failover struct {
+	primary Requester
+	standby Requester
+}
+
+// NewFailover returns a requester which fails over from primary to standby
+func NewFailover(primary Requester, standby Requester) *Failover {
+	return &Failover{primary: primary, standby: standby}
+}
+
+// Request sends the request to the primary, or to the standby if the primary fails.
+// This method is threadsafe.
+func (f *Failover) Request(request proto.Message, response proto.Message) *Error {
+	err := f.primary.Request(request, response)
+	if isConnectionFailure(err) {
+		response.Reset()
+		err = f.standby.Request(request, response)
+	}
+	return err
+}
+
+// RequestContext works like Request, but uses RequestContext of the requesters.
+// This method is threadsafe.
+func (f *Failover) RequestContext(ctx context.Context, request proto.Message, response proto.Message) *Error {
+	err := f.primary.RequestContext(ctx, request, response)
+	if isConnectionFailure(err) && ctx.Err() == nil {
+		response.Reset()
+		err = f.standby.RequestContext(ctx, request, response)
+	}
+	return err
+}
+
+// Close closes both requesters, returning the first error, if any
+func (f *Failover) Close() *Error {
+	err := f.primary.Close()
+	if standbyErr := f.standby.Close(); err == nil {
+		err = standbyErr
+	}
+	return err
+}
+
+// Returns true if err is caused by the connection to the node rather than by the node
+func isConnectionFailure(err *Error) bool {
+	return err != nil && (err.Category == "Connection" || err.Category == "Network")
+}
@@ -0,0 +1,174 @@
+package nano_client
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"nano_api"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// The steps below make up the wire protocol used by Session#request. Each step applies
+// the given read or write timeout, and the caller must hold the session mutex.
+
+// Marshals the request and writes it, consisting of the preamble, the request header frame
+// and the request frame. Buffered frames are flushed. As a partially written request can't
+// be taken back, the connection is closed if writing fails. Panics if the request message
+// has no request type.
+func (s *Session) writeRequest(request proto.Message, timeout time.Duration) *Error {
+	requestHeader := &nano_api.Request{
+		Type: nano_api.RequestTypeForMessage(proto.MessageName(request)),
+	}
+
+	if requestHeader.Type == nano_api.RequestType_INVALID {
+		panic("Invalid request type:" + proto.MessageName(request))
+	}
+
+	// Marshal the request before writing anything, so oversized requests fail early
+	msgBuffer, err := proto.Marshal(request)
+	if err != nil {
+		return wrapError(err, "Marshalling")
+	} else if s.MaxRequestBytes > 0 && len(msgBuffer) > s.MaxRequestBytes {
+		return newError(fmt.Sprintf("Request size %d exceeds the maximum of %d bytes", len(msgBuffer), s.MaxRequestBytes), "Marshalling")
+	} else if uint64(len(msgBuffer)) > MaxFrameSize {
+		// The length prefix would be truncated
+		return newError(fmt.Sprintf("Request size %d exceeds the maximum frame size", len(msgBuffer)), "Marshalling")
+	}
+	headerData, err := proto.Marshal(requestHeader)
+	if err != nil {
+		return wrapError(err, "Marshalling")
+	}
+
+	writeErr := s.writePreamble(timeout)
+	if writeErr == nil {
+		writeErr = s.writeFrame(headerData, timeout)
+	}
+	if writeErr == nil {
+		writeErr = s.writeFrame(msgBuffer, timeout)
+	}
+	if writeErr == nil {
+		writeErr = s.flushFrames(timeout)
+	}
+	if writeErr != nil && s.Connected {
+		s.Connected = false
+		s.connection.Close()
+	}
+	return writeErr
+}
+
+// Writes the preamble, which is constant for the lifetime of the connection
+func (s *Session) writePreamble(timeout time.Duration) *Error {
+	s.updateWriteDeadline(timeout)
+	if _, err := s.output().Write(s.preamble[:]); err != nil {
+		return writeError(err)
+	}
+	return nil
+}
+
+// Writes a frame, which is the data prefixed by its 32-bit big endian length
+func (s *Session) writeFrame(data []byte, timeout time.Duration) *Error {
+	var bufLen [4]byte
+	binary.BigEndian.PutUint32(bufLen[:], uint32(len(data)))
+	s.updateWriteDeadline(timeout)
+	if _, err := s.output().Write(bufLen[:]); err != nil {
+		return writeError(err)
+	}
+	s.updateWriteDeadline(timeout)
+	if _, err := s.output().Write(data); err != nil {
+		return writeError(err)
+	}
+	return nil
+}
+
+// Sends the buffered frames if write buffering is on. The response can only
+// arrive once they're sent.
+func (s *Session) flushFrames(timeout time.Duration) *Error {
+	if s.writer != nil {
+		s.updateWriteDeadline(timeout)
+		if err := s.writer.Flush(); err != nil {
+			return writeError(err)
+		}
+	}
+	return nil
+}
+
+// Reads and verifies the preamble of a response against the preamble of the connection
+func (s *Session) readPreamble(timeout time.Duration) *Error {
+	var preamble [4]byte
+	s.updateReadDeadline(timeout)
+	if _, err := io.ReadFull(s.reader, preamble[:]); err != nil {
+		return readError(err)
+	}
+	if preamble[0] != PROTOCOL_PREAMBLE_LEAD || preamble[1] != s.preamble[1] {
+		return newError("Invalid preamble", "Network")
+	} else if preamble[2] > byte(nano_api.APIVersion_VERSION_MAJOR) {
+		if !s.AllowNewerVersion {
+			return newError("Unsupported API version", "API")
+		}
+		if !s.versionWarned {
+			s.logf("Node uses API version %d.%d, which is newer than %d.%d", preamble[2], preamble[3],
+				nano_api.APIVersion_VERSION_MAJOR, nano_api.APIVersion_VERSION_MINOR)
+		}
+		s.versionWarned = true
+	}
+	return nil
+}
+
+// Reads a frame and returns its data, which is only valid until the next read
+func (s *Session) readFrame(timeout time.Duration) ([]byte, *Error) {
+	length, err := s.readFrameLength(timeout)
+	if err != nil {
+		return nil, err
+	}
+	return s.readFrameData(length, timeout)
+}
+
+// Reads the length prefix of a frame, and checks it against MaxFrameSize and
+// Session#MaxResponseBytes before anything is allocated. As the frame can't be
+// skipped, the connection is closed if it's too large.
+func (s *Session) readFrameLength(timeout time.Duration) (int, *Error) {
+	var bufLen [4]byte
+	s.updateReadDeadline(timeout)
+	if _, err := io.ReadFull(s.reader, bufLen[:]); err != nil {
+		return 0, readError(err)
+	}
+	length := uint64(binary.BigEndian.Uint32(bufLen[:]))
+	if length > MaxFrameSize || length > math.MaxInt ||
+		(s.MaxResponseBytes > 0 && length > uint64(s.MaxResponseBytes)) {
+		s.Connected = false
+		s.connection.Close()
+		return 0, newError(fmt.Sprintf("Response frame size %d exceeds the maximum size", length), "Network")
+	}
+	return int(length), nil
+}
+
+// Chunk size in bytes when reading frames with Session#OnReadProgress set
+const readProgressChunk = 64 * 1024
+
+// Reads the data of a frame whose length prefix has been read. The data is only valid
+// until the next read.
+func (s *Session) readFrameData(length int, timeout time.Duration) ([]byte, *Error) {
+	data := s.readBuffer(length)
+	s.updateReadDeadline(timeout)
+	if s.OnReadProgress == nil || length <= readProgressChunk {
+		if _, err := io.ReadFull(s.reader, data); err != nil {
+			return nil, readError(err)
+		}
+		return data, nil
+	}
+	for got := 0; got < length; {
+		end := got + readProgressChunk
+		if end > length {
+			end = length
+		}
+		if _, err := io.ReadFull(s.reader, data[got:end]); err != nil {
+			return nil, readError(err)
+		}
+		got = end
+		s.OnReadProgress(got, length)
+	}
+	return data, nil
+}
@@ -0,0 +1,33 @@
+package nano_client
+
+import (
+	"nano_api"
+	"testing"
+)
+
+// Compares sending each request in several writes, which is the default, with
+// coalescing them into a single write using write buffering
+func BenchmarkRequest(b *testing.B) {
+	for _, network := range []string{"unix", "tcp"} {
+		for _, buffered := range []bool{false, true} {
+			name := network + "/multi-write"
+			if buffered {
+				name = network + "/coalesced"
+			}
+			b.Run(name, func(b *testing.B) {
+				node := newFakeNodeOn(b, network, pingHandler)
+				s := node.connect(b)
+				s.SetWriteBuffering(buffered)
+				request, response := &nano_api.ReqPing{Id: 1}, &nano_api.ResPing{}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := s.Request(request, response); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package nano_client
+
+import (
+	"nano_api"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// InFlightInfo describes a request which is being executed by a session
+type InFlightInfo struct {
+	// Type of the request
+	Type nano_api.RequestType
+	// Connection string of the node the request was sent to
+	Node string
+	// Label of the session, see Session#Label
+	Label string
+	// When the request was sent
+	Start time.Time
+	// How long the request has been executing
+	Elapsed time.Duration
+}
+
+// InFlight returns the requests currently being executed by the session. As a session
+// executes one request at a time, this holds at most one request. Unlike requests, this
+// doesn't wait for the session mutex, so it can be used to diagnose a hung session.
+// This method is threadsafe.
+func (s *Session) InFlight() []InFlightInfo {
+	s.inFlightMutex.Lock()
+	defer s.inFlightMutex.Unlock()
+
+	if s.inFlight == nil {
+		return nil
+	}
+	info := *s.inFlight
+	info.Elapsed = time.Since(info.Start)
+	return []InFlightInfo{info}
+}
+
+// Records the request as in flight until the returned function is called.
+// The caller must hold the session mutex.
+func (s *Session) trackInFlight(request proto.Message) func() {
+	info := &InFlightInfo{
+		Type:  nano_api.RequestTypeForMessage(proto.MessageName(request)),
+		Node:  s.connectionString,
+		Label: s.Label,
+		Start: time.Now(),
+	}
+	s.inFlightMutex.Lock()
+	s.inFlight = info
+	s.inFlightMutex.Unlock()
+
+	return func() {
+		s.inFlightMutex.Lock()
+		s.inFlight = nil
+		s.inFlightMutex.Unlock()
+	}
+}
@@ -0,0 +1,58 @@
+package nano_client
+
+import (
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// RequestFunc sends a request and stores the result in the response
+type RequestFunc func(request proto.Message, response proto.Message) *Error
+
+// Interceptor wraps a RequestFunc, such as to log, modify or retry requests.
+// An interceptor calls next to continue the request, and may skip the call
+// to fail the request.
+type Interceptor func(next RequestFunc) RequestFunc
+
+// Use adds an interceptor to the requests of the session. Interceptors run in
+// the order they are added, so the first interceptor sees the request first and
+// the response last. Rate limiting applies each time the last interceptor calls
+// next. Must be called before the first request.
+func (s *Session) Use(interceptor Interceptor) {
+	s.interceptors = append(s.interceptors, interceptor)
+}
+
+// Wraps fn in the interceptors of the session. Nil messages fail with a Marshalling
+// error before the interceptors run, as unmarshalling into a nil response would panic
+// after the request has been sent.
+func (s *Session) intercept(fn RequestFunc) RequestFunc {
+	for i := len(s.interceptors) - 1; i >= 0; i-- {
+		fn = s.interceptors[i](fn)
+	}
+	return func(request proto.Message, response proto.Message) *Error {
+		if err := checkMessages(request, response); err != nil {
+			return err
+		}
+		return fn(request, response)
+	}
+}
+
+// Returns a Marshalling error if the request or response is nil, as cloning or
+// unmarshalling into a nil message panics
+func checkMessages(request proto.Message, response proto.Message) *Error {
+	if isNilMessage(request) {
+		return newError("Request message is nil", "Marshalling")
+	} else if isNilMessage(response) {
+		return newError("Response message is nil", "Marshalling")
+	}
+	return nil
+}
+
+// Reports whether m is nil or a nil pointer
+func isNilMessage(m proto.Message) bool {
+	if m == nil {
+		return true
+	}
+	v := reflect.ValueOf(m)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
@@ -0,0 +1,76 @@
+package nano_client
+
+import (
+	"context"
+	"nano_api"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestNilMessages(t *testing.T) {
+	node := newFakeNode(t, pingHandler)
+	s := node.connect(t)
+	requesters := map[string]Requester{
+		"Session":      s,
+		"SingleFlight": NewSingleFlight(s),
+		"Broadcaster":  NewBroadcaster(s, s),
+	}
+	var nilRequest *nano_api.ReqPing
+	var nilResponse *nano_api.ResPing
+	messages := []struct {
+		name     string
+		request  *nano_api.ReqPing
+		response *nano_api.ResPing
+	}{
+		{"nil request", nilRequest, &nano_api.ResPing{}},
+		{"nil response", &nano_api.ReqPing{}, nilResponse},
+	}
+	for name, requester := range requesters {
+		for _, m := range messages {
+			if err := requester.Request(m.request, m.response); err == nil || err.Category != "Marshalling" {
+				t.Errorf("%s Request with %s: expected a Marshalling error, got %v", name, m.name, err)
+			}
+			if err := requester.RequestContext(context.Background(), m.request, m.response); err == nil || err.Category != "Marshalling" {
+				t.Errorf("%s RequestContext with %s: expected a Marshalling error, got %v", name, m.name, err)
+			}
+		}
+	}
+
+	b := NewBroadcaster(s, s)
+	errs := b.RequestAll(&nano_api.ReqPing{}, []proto.Message{&nano_api.ResPing{}, nilResponse})
+	if errs[0] != nil || errs[1] == nil || errs[1].Category != "Marshalling" {
+		t.Errorf("RequestAll: expected a Marshalling error for the nil response only, got %v", errs)
+	}
+	errs = b.RequestAll(nilRequest, []proto.Message{&nano_api.ResPing{}, &nano_api.ResPing{}})
+	if errs[0] == nil || errs[1] == nil {
+		t.Errorf("RequestAll: expected errors for the nil request, got %v", errs)
+	}
+
+	// The session is still in sync
+	response := &nano_api.ResPing{}
+	if err := s.Request(&nano_api.ReqPing{Id: 3}, response); err != nil || response.Id != 3 {
+		t.Fatalf("request after nil messages failed: %v", err)
+	}
+}
+
+func TestReadResponseBodyNilResponse(t *testing.T) {
+	node := newFakeNode(t, pingHandler)
+	s := node.connect(t)
+
+	if err := s.SendRequest(&nano_api.ReqPing{Id: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.PeekResponseHeader(); err != nil {
+		t.Fatal(err)
+	}
+	var nilResponse *nano_api.ResPing
+	if err := s.ReadResponseBody(nilResponse); err == nil || err.Category != "Marshalling" {
+		t.Fatalf("expected a Marshalling error, got %v", err)
+	}
+	// The body can still be read
+	response := &nano_api.ResPing{}
+	if err := s.ReadResponseBody(response); err != nil || response.Id != 5 {
+		t.Fatalf("reading the body after the nil response failed: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package nano_client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"reflect"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+// RequestJSON sends a request given as JSON and returns the response as JSON. The
+// requestType is the lowercase request type name, such as account_pending, which is
+// used to look up the request and response messages. Response fields with default
+// values are included in the JSON output.
+// This method is threadsafe.
+func (s *Session) RequestJSON(requestType string, jsonBody []byte) ([]byte, *Error) {
+	return s.RequestJSONContext(context.Background(), requestType, jsonBody)
+}
+
+// RequestJSONContext works like RequestJSON, but the request is sent with
+// Session#RequestContext and is aborted when ctx is cancelled.
+func (s *Session) RequestJSONContext(ctx context.Context, requestType string, jsonBody []byte) ([]byte, *Error) {
+	var out bytes.Buffer
+	if err := s.RequestJSONStream(ctx, requestType, jsonBody, &out); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// RequestJSONStream works like RequestJSONContext, but the JSON response is marshalled
+// directly to w rather than into a buffer. This lowers peak memory use for large
+// responses. Errors other than Marshalling errors occur before anything is written.
+func (s *Session) RequestJSONStream(ctx context.Context, requestType string, jsonBody []byte, w io.Writer) *Error {
+
+	// Get protobuf message types by name
+	msgType := proto.MessageType("nano.api.req_" + requestType)
+	responseMsgType := proto.MessageType("nano.api.res_" + requestType)
+	if msgType == nil || responseMsgType == nil {
+		return newError("Could not find protobuffer message types for "+requestType, "Marshalling")
+	}
+	request := reflect.New(msgType.Elem()).Interface().(proto.Message)
+	response := reflect.New(responseMsgType.Elem()).Interface().(proto.Message)
+
+	if err := jsonpb.Unmarshal(bytes.NewReader(jsonBody), request); err != nil {
+		return wrapError(err, "Marshalling")
+	}
+	if err := s.RequestContext(ctx, request, response); err != nil {
+		return err
+	}
+
+	m := &jsonpb.Marshaler{EmitDefaults: true}
+	if err := m.Marshal(w, response); err != nil {
+		return wrapError(err, "Marshalling")
+	}
+	return nil
+}
+
+// RequestByName sends a request given by its lowercase type name, such as account_pending,
+// and fields keyed by their JSON names. The fields are converted as if given as JSON, so
+// unknown fields and values of the wrong type fail with a Marshalling error.
+// This method is threadsafe.
+func (s *Session) RequestByName(typeName string, fields map[string]interface{}, response proto.Message) *Error {
+	msgType := proto.MessageType("nano.api.req_" + typeName)
+	if msgType == nil {
+		return newError("Could not find protobuffer message type for "+typeName, "Marshalling")
+	}
+	request := reflect.New(msgType.Elem()).Interface().(proto.Message)
+
+	jsonBody, err := json.Marshal(fields)
+	if err != nil {
+		return wrapError(err, "Marshalling")
+	}
+	if err := jsonpb.Unmarshal(bytes.NewReader(jsonBody), request); err != nil {
+		return wrapError(err, "Marshalling")
+	}
+	return s.Request(request, response)
+}
@@ -0,0 +1,176 @@
+package nano_client
+
+import (
+	"encoding/binary"
+	"io"
+	"nano_api"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// A handler of a fake node, which writes the response to a request to conn
+type nodeHandler func(conn net.Conn, header *nano_api.Request, body []byte)
+
+// fakeNode speaks the wire protocol of a node over a domain socket or TCP, passing
+// each request to the handler. It's closed when the test ends.
+type fakeNode struct {
+	listener    net.Listener
+	handler     nodeHandler
+	mutex       sync.Mutex
+	connections []net.Conn
+}
+
+// Starts a fake node serving requests with handler on a domain socket
+func newFakeNode(t testing.TB, handler nodeHandler) *fakeNode {
+	return newFakeNodeOn(t, "unix", handler)
+}
+
+// Starts a fake node serving requests with handler, listening on a domain socket if
+// network is "unix", and on a loopback port if it's "tcp"
+func newFakeNodeOn(t testing.TB, network string, handler nodeHandler) *fakeNode {
+	address := "127.0.0.1:0"
+	if network == "unix" {
+		address = filepath.Join(t.TempDir(), "node")
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &fakeNode{listener: listener, handler: handler}
+	go node.serve()
+	t.Cleanup(node.close)
+	return node
+}
+
+// Returns the connection string of the node
+func (node *fakeNode) connectionString() string {
+	if addr, ok := node.listener.Addr().(*net.TCPAddr); ok {
+		return TCPAddr(addr.IP.String(), addr.Port)
+	}
+	return UnixAddr(node.listener.Addr().String())
+}
+
+// Returns a session connected to the node, which is closed when the test ends
+func (node *fakeNode) connect(t testing.TB) *Session {
+	s := &Session{}
+	if err := s.Connect(node.connectionString()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func (node *fakeNode) serve() {
+	for {
+		conn, err := node.listener.Accept()
+		if err != nil {
+			return
+		}
+		node.mutex.Lock()
+		node.connections = append(node.connections, conn)
+		node.mutex.Unlock()
+		go node.serveConnection(conn)
+	}
+}
+
+// Reads requests from conn until it's closed
+func (node *fakeNode) serveConnection(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var preamble [4]byte
+		if _, err := io.ReadFull(conn, preamble[:]); err != nil {
+			return
+		}
+		headerData, err := readTestFrame(conn)
+		if err != nil {
+			return
+		}
+		body, err := readTestFrame(conn)
+		if err != nil {
+			return
+		}
+		header := &nano_api.Request{}
+		if err := proto.Unmarshal(headerData, header); err != nil {
+			return
+		}
+		node.handler(conn, header, body)
+	}
+}
+
+func (node *fakeNode) close() {
+	node.listener.Close()
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	for _, conn := range node.connections {
+		conn.Close()
+	}
+}
+
+func readTestFrame(r io.Reader) ([]byte, error) {
+	var bufLen [4]byte
+	if _, err := io.ReadFull(r, bufLen[:]); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(bufLen[:]))
+	_, err := io.ReadFull(r, data)
+	return data, err
+}
+
+// Returns data prefixed by its length
+func testFrame(data []byte) []byte {
+	frame := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	return append(frame, data...)
+}
+
+// Returns a response consisting of the preamble, the header frame and the body frame
+func testResponse(header *nano_api.Response, body proto.Message) []byte {
+	headerData, _ := proto.Marshal(header)
+	bodyData, _ := proto.Marshal(body)
+	response := []byte{PROTOCOL_PREAMBLE_LEAD, PROTOCOL_ENCODING,
+		byte(nano_api.APIVersion_VERSION_MAJOR), byte(nano_api.APIVersion_VERSION_MINOR)}
+	response = append(response, testFrame(headerData)...)
+	return append(response, testFrame(bodyData)...)
+}
+
+// Answers ping requests with the id of the request
+func pingHandler(conn net.Conn, header *nano_api.Request, body []byte) {
+	ping := &nano_api.ReqPing{}
+	proto.Unmarshal(body, ping)
+	conn.Write(testResponse(&nano_api.Response{Type: header.Type}, &nano_api.ResPing{Id: ping.Id}))
+}
+
+// faultConn injects a fault into the responses of a fake node by pausing for delay
+// after writing the first split bytes, such as to let the client time out while a
+// response is in transit
+type faultConn struct {
+	net.Conn
+	split int
+	delay time.Duration
+}
+
+func (c *faultConn) Write(b []byte) (int, error) {
+	split := c.split
+	if split > len(b) {
+		split = len(b)
+	}
+	n, err := c.Conn.Write(b[:split])
+	if err != nil {
+		return n, err
+	}
+	time.Sleep(c.delay)
+	m, err := c.Conn.Write(b[split:])
+	return n + m, err
+}
+
+// Returns a handler which writes the responses of handler through a faultConn
+func withFault(handler nodeHandler, split int, delay time.Duration) nodeHandler {
+	return func(conn net.Conn, header *nano_api.Request, body []byte) {
+		handler(&faultConn{conn, split, delay}, header, body)
+	}
+}
@@ -0,0 +1,97 @@
+package nano_client
+
+import (
+	"context"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Ordered wraps a Requester so that requests sharing a key, such as the account they
+// operate on, are sent one at a time in the order they were made. Requests with
+// different keys are sent concurrently.
+type Ordered struct {
+	mutex     sync.Mutex
+	requester Requester
+	keyFn     func(proto.Message) string
+	queues    map[string]*orderedQueue
+}
+
+// The requests in progress or waiting for a key
+type orderedQueue struct {
+	// Closed when the last request made for the key is done
+	tail    chan struct{}
+	pending int
+}
+
+// NewOrderedRequester returns a requester which orders requests by the key returned by
+// keyFn. Requests for which keyFn returns an empty key are not ordered.
+func NewOrderedRequester(r Requester, keyFn func(proto.Message) string) *Ordered {
+	return &Ordered{requester: r, keyFn: keyFn, queues: make(map[string]*orderedQueue)}
+}
+
+// Request sends the request once the previous requests with the same key are done.
+// This method is threadsafe.
+func (o *Ordered) Request(request proto.Message, response proto.Message) *Error {
+	return o.do(context.Background(), request, func() *Error {
+		return o.requester.Request(request, response)
+	})
+}
+
+// RequestContext works like Request, but uses RequestContext of the wrapped Requester.
+// If ctx is cancelled while waiting for previous requests, a Context error is returned.
+// This method is threadsafe.
+func (o *Ordered) RequestContext(ctx context.Context, request proto.Message, response proto.Message) *Error {
+	return o.do(ctx, request, func() *Error {
+		return o.requester.RequestContext(ctx, request, response)
+	})
+}
+
+// Close closes the wrapped Requester
+func (o *Ordered) Close() *Error {
+	return o.requester.Close()
+}
+
+// Calls send once the previous requests with the same key are done
+func (o *Ordered) do(ctx context.Context, request proto.Message, send func() *Error) *Error {
+	key := o.keyFn(request)
+	if key == "" {
+		return send()
+	}
+
+	o.mutex.Lock()
+	queue := o.queues[key]
+	if queue == nil {
+		queue = &orderedQueue{}
+		o.queues[key] = queue
+	}
+	previous := queue.tail
+	done := make(chan struct{})
+	queue.tail = done
+	queue.pending++
+	o.mutex.Unlock()
+
+	finish := func() {
+		o.mutex.Lock()
+		if queue.pending--; queue.pending == 0 {
+			delete(o.queues, key)
+		}
+		o.mutex.Unlock()
+		close(done)
+	}
+
+	if previous != nil {
+		select {
+		case <-previous:
+		case <-ctx.Done():
+			// Later requests must still wait for the previous ones
+			go func() {
+				<-previous
+				finish()
+			}()
+			return wrapError(ctx.Err(), "Context")
+		}
+	}
+	defer finish()
+	return send()
+}
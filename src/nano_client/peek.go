@@ -0,0 +1,138 @@
+package nano_client
+
+import (
+	"io"
+	"io/ioutil"
+	"nano_api"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// The state of a request sent with SendRequest
+const (
+	peekNone = iota
+	// The response has not been read
+	peekResponse
+	// The response header has been peeked, but the body has not been read
+	peekBody
+)
+
+// ErrPeekPending is returned when a request is made while a response of SendRequest has
+// not been read completely
+var ErrPeekPending = newError("The response of SendRequest must be read first", "Network")
+
+// SendRequest sends the request without reading the response, which must then be read
+// with PeekResponseHeader followed by ReadResponseBody or DrainResponseBody. This is a
+// low-level API for diagnosing protocol issues, such as frame desyncs and oversized frames.
+// This method is threadsafe.
+func (s *Session) SendRequest(request proto.Message) *Error {
+	if isNilMessage(request) {
+		return newError("Request message is nil", "Marshalling")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	} else if !s.Connected {
+		return newError("Not connected", "Network")
+	} else if s.peekState != peekNone {
+		return ErrPeekPending
+	}
+	err := s.writeRequest(request, s.RWTimeout)
+	if err == nil {
+		s.peekState = peekResponse
+	}
+	return err
+}
+
+// PeekResponseHeader reads the response header of a request sent with SendRequest, and
+// returns it along with the length of the response body. This must be followed by
+// ReadResponseBody or DrainResponseBody, even if the header reports an error or fails
+// to unmarshal, as the body frame follows the header regardless.
+// This method is threadsafe.
+func (s *Session) PeekResponseHeader() (*nano_api.Response, int, *Error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.peekState != peekResponse {
+		return nil, 0, newError("No response to peek, use SendRequest first", "Network")
+	}
+	if err := s.readPreamble(s.RWTimeout); err != nil {
+		s.abortPeek()
+		return nil, 0, err
+	}
+	data, err := s.readFrame(s.RWTimeout)
+	if err != nil {
+		s.abortPeek()
+		return nil, 0, err
+	}
+	header := &nano_api.Response{}
+	headerErr := proto.Unmarshal(data, header)
+	length, err := s.readFrameLength(s.RWTimeout)
+	if err != nil {
+		s.abortPeek()
+		return nil, 0, err
+	}
+	s.peekState = peekBody
+	s.peekLength = length
+	if headerErr != nil {
+		return nil, length, wrapError(headerErr, "Marshalling")
+	}
+	return header, length, nil
+}
+
+// ReadResponseBody reads the response body after PeekResponseHeader into response. A nil
+// response fails with a Marshalling error, and the body is left to be read.
+// This method is threadsafe.
+func (s *Session) ReadResponseBody(response proto.Message) *Error {
+	if isNilMessage(response) {
+		return newError("Response message is nil", "Marshalling")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.peekState != peekBody {
+		return newError("No response body to read, use PeekResponseHeader first", "Network")
+	}
+	data, err := s.readFrameData(s.peekLength, s.RWTimeout)
+	if err != nil {
+		s.abortPeek()
+		return err
+	}
+	s.peekState = peekNone
+	if err := proto.Unmarshal(data, response); err != nil {
+		return wrapError(err, "Marshalling")
+	}
+	return nil
+}
+
+// DrainResponseBody discards the response body after PeekResponseHeader.
+// This method is threadsafe.
+func (s *Session) DrainResponseBody() *Error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.peekState != peekBody {
+		return newError("No response body to drain, use PeekResponseHeader first", "Network")
+	}
+	s.updateReadDeadline(s.RWTimeout)
+	if _, err := io.CopyN(ioutil.Discard, s.reader, int64(s.peekLength)); err != nil {
+		s.abortPeek()
+		return readError(err)
+	}
+	s.peekState = peekNone
+	return nil
+}
+
+// Closes the connection after a failed read of a peeked response, as the rest of the
+// response can't be told apart from the next one. Reconnect makes the session usable again.
+func (s *Session) abortPeek() {
+	s.peekState = peekNone
+	if s.Connected {
+		s.Connected = false
+		s.connection.Close()
+	}
+}
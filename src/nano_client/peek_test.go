@@ -0,0 +1,57 @@
+package nano_client
+
+import (
+	"errors"
+	"nano_api"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProbeSkipsPeekedRequest(t *testing.T) {
+	node := newFakeNode(t, pingHandler)
+	s := &Session{ProbeInterval: 10 * time.Millisecond, RWTimeout: time.Second}
+	if err := s.Connect(node.connectionString()); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.SendRequest(&nano_api.ReqPing{Id: 7}); err != nil {
+		t.Fatal(err)
+	}
+	// Probes are due while the response is pending, and must leave the connection alone
+	time.Sleep(100 * time.Millisecond)
+
+	if _, _, err := s.PeekResponseHeader(); err != nil {
+		t.Fatalf("peek failed: %v", err)
+	}
+	response := &nano_api.ResPing{}
+	if err := s.ReadResponseBody(response); err != nil {
+		t.Fatalf("reading the body failed: %v", err)
+	}
+	if response.Id != 7 {
+		t.Errorf("expected id 7, got %d", response.Id)
+	}
+}
+
+func TestPeekReadErrorClosesConnection(t *testing.T) {
+	node := newFakeNode(t, func(conn net.Conn, header *nano_api.Request, body []byte) {
+		conn.Close()
+	})
+	s := node.connect(t)
+
+	// The request body isn't empty, so the node closes the connection only once
+	// all writes are done
+	if err := s.SendRequest(&nano_api.ReqPing{Id: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.PeekResponseHeader(); !errors.Is(err, ErrPeerClosed) {
+		t.Fatalf("expected ErrPeerClosed, got %v", err)
+	}
+	if s.Connected {
+		t.Error("expected the connection to be closed")
+	}
+	if err := s.SendRequest(&nano_api.ReqPing{}); errors.Is(err, ErrPeekPending) {
+		t.Error("the failed peek must not leave a response pending")
+	}
+}
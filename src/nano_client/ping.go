@@ -0,0 +1,45 @@
+package nano_client
+
+import (
+	"nano_api"
+	"time"
+)
+
+// PingN sends n pings to the node and returns the average, minimum and maximum
+// roundtrip time, including marshalling. If a ping fails, PingN stops and returns
+// the error along with the statistics of the pings completed so far.
+// This method is threadsafe.
+func (s *Session) PingN(n int) (avg, min, max time.Duration, err *Error) {
+	var total time.Duration
+	completed := 0
+	for ; completed < n; completed++ {
+		start := time.Now()
+		if err = s.Request(&nano_api.ReqPing{}, &nano_api.ResPing{}); err != nil {
+			break
+		}
+		elapsed := time.Since(start)
+		total += elapsed
+		if completed == 0 || elapsed < min {
+			min = elapsed
+		}
+		if elapsed > max {
+			max = elapsed
+		}
+	}
+	if completed > 0 {
+		avg = total / time.Duration(completed)
+	}
+	return avg, min, max, err
+}
+
+// Probe connects to the node, sends a single ping and closes the connection. This
+// checks that the node is reachable and speaks the protocol, without managing a Session.
+// The timeout is used for connecting as well as for the ping.
+func Probe(connectionString string, timeout time.Duration) *Error {
+	session := &Session{ConnTimeout: timeout, RWTimeout: timeout}
+	if err := session.Connect(connectionString); err != nil {
+		return err
+	}
+	defer session.Close()
+	return session.Request(&nano_api.ReqPing{}, &nano_api.ResPing{})
+}
@@ -0,0 +1,52 @@
+package nano_client
+
+import (
+	"context"
+	"nano_api"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/time/rate"
+)
+
+// Applies the rate limit of the request type, if any. Depending on Session#RateLimitWait,
+// this either waits for the limiter or fails if the limit is exceeded.
+func (s *Session) throttle(ctx context.Context, request proto.Message) *Error {
+	if len(s.RateLimits) == 0 {
+		return nil
+	}
+	requestType := nano_api.RequestTypeForMessage(proto.MessageName(request))
+	limit, ok := s.RateLimits[requestType]
+	if !ok {
+		return nil
+	}
+
+	s.limiterMutex.Lock()
+	if s.limiters == nil {
+		s.limiters = make(map[nano_api.RequestType]*rate.Limiter)
+	}
+	limiter := s.limiters[requestType]
+	if limiter == nil {
+		// Allow bursts of up to one second worth of requests
+		burst := int(limit)
+		if burst < 1 {
+			burst = 1
+		}
+		limiter = rate.NewLimiter(limit, burst)
+		s.limiters[requestType] = limiter
+	}
+	s.limiterMutex.Unlock()
+
+	var err *Error
+	if s.RateLimitWait {
+		if waitErr := limiter.Wait(ctx); waitErr != nil {
+			if ctx.Err() != nil {
+				err = wrapError(ctx.Err(), "Context")
+			} else {
+				err = wrapError(waitErr, "RateLimited")
+			}
+		}
+	} else if !limiter.Allow() {
+		err = newError("Rate limit exceeded for "+requestType.String(), "RateLimited")
+	}
+	return err
+}
@@ -0,0 +1,37 @@
+package nano_client
+
+import (
+	"github.com/golang/protobuf/proto"
+)
+
+// RequestWithRetryOn sends the request, retrying up to maxRetries times if it fails with
+// an error whose Category is one of categories, such as "Network". Other errors are
+// returned immediately. A request which fails on the connection, such as with
+// ErrPeerClosed, leaves the session disconnected, so the session is reconnected before
+// retrying, unless a concurrent caller already did. A closed or draining session isn't
+// reconnected, and ErrClosed or ErrDraining is returned.
+// Only idempotent requests should be retried.
+// This method is threadsafe.
+func (s *Session) RequestWithRetryOn(request proto.Message, response proto.Message, categories []string, maxRetries int) *Error {
+	err := s.Request(request, response)
+	for retry := 0; retry < maxRetries && err != nil && hasCategory(err, categories); retry++ {
+		// Another caller may have reconnected the session already
+		if _, connErr := s.ReconnectIfDisconnected(); connErr != nil {
+			err = connErr
+			continue
+		}
+		response.Reset()
+		err = s.Request(request, response)
+	}
+	return err
+}
+
+// Returns true if the category of err is one of categories
+func hasCategory(err *Error, categories []string) bool {
+	for _, category := range categories {
+		if err.Category == category {
+			return true
+		}
+	}
+	return false
+}
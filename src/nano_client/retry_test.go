@@ -0,0 +1,33 @@
+package nano_client
+
+import (
+	"nano_api"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestRequestWithRetryOnConcurrently(t *testing.T) {
+	// Each connection serves a single response
+	node := newFakeNode(t, func(conn net.Conn, header *nano_api.Request, body []byte) {
+		pingHandler(conn, header, body)
+		conn.Close()
+	})
+	s := node.connect(t)
+
+	// A caller only fails again if another one used up the connection in the meantime,
+	// so each caller needs fewer retries than there are callers
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(id uint32) {
+			defer wg.Done()
+			response := &nano_api.ResPing{}
+			err := s.RequestWithRetryOn(&nano_api.ReqPing{Id: id}, response, []string{"Network"}, 16)
+			if err != nil || response.Id != id {
+				t.Errorf("request %d: got id %d, error %v", id, response.Id, err)
+			}
+		}(uint32(i + 1))
+	}
+	wg.Wait()
+}
@@ -1,18 +1,26 @@
 package nano_client
 
 import (
+	"bufio"
 	"context"
-	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"math"
 	"nano_api"
 	"net"
 	"net/url"
+	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/golang/protobuf/proto"
+	"golang.org/x/time/rate"
 )
 
 // Error encapsulates the error code, message and category.
@@ -20,9 +28,35 @@ import (
 // Category are usually set for errors transmitted by the node.
 // Implements the Go error interface.
 type Error struct {
-	Code     int
-	Message  string
-	Category string
+	Code     int    `json:"code"`
+	Message  string `json:"message"`
+	Category string `json:"category"`
+	// The response body which failed to unmarshal, if Session#ReturnPartialOnError is set.
+	// This is base64 encoded in JSON.
+	Raw []byte `json:"raw,omitempty"`
+	// The type declared by the response header, such as ACCOUNT_PENDING, if the error
+	// occurred after a valid header was received
+	ResponseType string `json:"responseType,omitempty"`
+	// The underlying error, if any
+	cause error
+	// The sentinel error, such as ErrPeerClosed, which this is a copy of
+	sentinel *Error
+}
+
+// Creates an error with the given message and category
+func newError(message string, category string) *Error {
+	return &Error{1, message, category, nil, "", nil, nil}
+}
+
+// Creates an error wrapping an underlying error, such as a net or proto error
+func wrapError(cause error, category string) *Error {
+	return &Error{1, cause.Error(), category, nil, "", cause, nil}
+}
+
+// Returns a copy of the sentinel error e carrying the underlying cause. The copy
+// matches e with errors.Is.
+func (e *Error) withCause(cause error) *Error {
+	return &Error{e.Code, e.Message, e.Category, nil, "", cause, e}
 }
 
 // Returns an error string in the format ERRORCODE:CATEGORY:MESSAGE where
@@ -36,84 +70,705 @@ func (e *Error) Error() string {
 	}
 }
 
+// Unwrap returns the underlying error, such as a *net.OpError, or nil if the error
+// was not caused by another error. This allows inspecting the cause with errors.As.
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is reports whether e is a copy of target carrying an underlying error. This allows
+// matching sentinel errors such as ErrPeerClosed with errors.Is, while errors.As
+// and errors.Is can still inspect the cause.
+func (e *Error) Is(target error) bool {
+	return e.sentinel != nil && error(e.sentinel) == target
+}
+
+// MarshalJSON produces a stable error envelope of the form
+// {"error":{"code":CODE,"message":MESSAGE,"category":CATEGORY}}
+func (e *Error) MarshalJSON() ([]byte, error) {
+	type errorFields Error
+	return json.Marshal(struct {
+		Error *errorFields `json:"error"`
+	}{(*errorFields)(e)})
+}
+
+// Requester is implemented by types which can send a request to a node, such as
+// Session and request wrappers like Breaker. Wrappers can thus be composed freely.
+type Requester interface {
+	Request(request proto.Message, response proto.Message) *Error
+	RequestContext(ctx context.Context, request proto.Message, response proto.Message) *Error
+	Close() *Error
+}
+
+// Protobuf encoding
+const PROTOCOL_ENCODING = 0
+const PROTOCOL_PREAMBLE_LEAD = 'N'
+
+// Frames are prefixed by a 32-bit big endian length in all API versions up to 1,
+// which limits the size of a request or response body
+const MaxFrameSize = math.MaxUint32
+
+// ErrClosed is returned when a request is made on a session which has been closed with Session#Close,
+// and when Session#Close interrupts a request in progress. In the latter case, a copy carrying the
+// underlying error is returned, so use errors.Is to check for ErrClosed. Unlike ErrPeerClosed, it
+// signals a deliberate close, after which the session should not be reconnected.
+var ErrClosed = newError("Session closed", "Connection")
+
+// ErrPeerClosed is returned when the node closed the connection during a request. It's returned
+// as a copy carrying the underlying io.EOF, so use errors.Is to check for ErrPeerClosed.
+var ErrPeerClosed = newError("Connection closed by node", "Connection")
+
+// ErrDraining is returned when a request is made on a session which is being drained with Session#Drain
+var ErrDraining = newError("Session is draining", "Draining")
+
+// The error category which marks a node error as a busy signal. Such errors are returned
+// with ErrNodeBusy as their cause.
+const NodeBusyCategory = "error_busy"
+
+// ErrNodeBusy is the cause of node errors signalling that the node is too busy to serve
+// the request. Check for it with errors.Is, which keeps the node's code and message
+// available. Callers should back off before retrying.
+var ErrNodeBusy = newError("Node is busy", "Busy")
+
 // A Session with a Nano node.
 type Session struct {
-	mutex      sync.Mutex
-	connection net.Conn
+	mutex            sync.Mutex
+	connection       net.Conn
+	reader           *bufio.Reader
+	writer           *bufio.Writer
+	writeBuffering   bool
+	connectionString string
+	connStrings      []string
+	byteTotals       *byteTotals
+	counting         atomic.Value
+	probeStop        chan struct{}
+	closed           bool
+	draining         int32
+	preamble         [4]byte
+	buffer           []byte
+	connectedAt      time.Time
+	header           *nano_api.Response
+	connectTime      time.Duration
+	handshakeTime    time.Duration
+	peekState        int
+	peekLength       int
+	versionWarned    bool
+	limiterMutex     sync.Mutex
+	limiters         map[nano_api.RequestType]*rate.Limiter
+	interceptors     []Interceptor
+	inFlightMutex    sync.Mutex
+	inFlight         *InFlightInfo
 	// True if the session has been connected to the node
 	Connected bool
 	// Read and Write timeout. Default is 30 seconds.
+	RWTimeout time.Duration
+	// Connection timeout. Default is 15 seconds.
+	ConnTimeout time.Duration
+	// Read and Write timeout in seconds.
+	// Deprecated: Use RWTimeout. Only used if RWTimeout is not set.
 	TimeoutReadWrite int
-	// Connection timeout. Default is 10 seconds.
+	// Connection timeout in seconds.
+	// Deprecated: Use ConnTimeout. Only used if ConnTimeout is not set.
 	TimeoutConnection int
+	// Interval between background health probes. If a probe fails, the connection
+	// is closed and redialed so the next Request hits a healthy socket.
+	// Default is 0, which disables probing.
+	ProbeInterval time.Duration
+	// Read and Write timeout for health probes. Default is 2 seconds.
+	ProbeTimeout time.Duration
+	// Maximum size in bytes of a marshalled request body. Larger requests fail with
+	// a Marshalling error before anything is sent. Default is 0, which means no limit.
+	MaxRequestBytes int
+	// Maximum size in bytes of a response frame. A larger frame fails the request with a
+	// Network error before it's read, and the connection is closed as the frame can't be
+	// skipped. Default is 0, which means frames up to MaxFrameSize are accepted.
+	MaxResponseBytes int
+	// Size in bytes of the read-ahead buffer used for responses. Default is 4096.
+	// As only one request is outstanding at a time, the buffer never holds data
+	// beyond the current response.
+	ReadAheadSize int
+	// If true, Connect does not check that the socket file of a local connection
+	// exists before dialing. The check only serves to give a friendlier error.
+	SkipSocketCheck bool
+	// Maximum requests per second by request type. Request types without a limit
+	// are not rate limited. Must be set before the first request.
+	RateLimits map[nano_api.RequestType]rate.Limit
+	// If true, requests exceeding the rate limit wait for their turn, or until the
+	// context passed to RequestContext is done. Otherwise, they fail immediately
+	// with a RateLimited error.
+	RateLimitWait bool
+	// Frames up to this size in bytes are read into a buffer which is kept and reused
+	// for subsequent reads, growing to the largest frame seen. Larger frames use a
+	// temporary buffer so a single huge response doesn't pin memory. Default is 1 MiB.
+	ResponseBufferCap int
+	// If true, a response body which fails to unmarshal is not discarded. The response
+	// holds the fields decoded before the failure, and the Marshalling error holds the raw
+	// body in Error#Raw, which RequestRawResponse returns as well. This is meant for
+	// diagnostics. Default is false.
+	ReturnPartialOnError bool
+	// Count applied by Session#Do to account pending requests which leave Count zero.
+	// Default is 0, which leaves the count to the node.
+	DefaultPendingCount uint64
+	// Threshold applied by Session#Do to account pending requests without a Threshold.
+	// Default is empty, which means no threshold.
+	DefaultPendingThreshold string
+	// If set, called after creating the socket and before dialing, allowing socket
+	// options such as SO_REUSEADDR to be set. This is passed to net.Dialer#Control.
+	DialControl func(network, address string, c syscall.RawConn) error
+	// If set, a connection older than this is closed and redialed before the next request.
+	// This avoids errors when the node closes aged connections. Default is 0, which means
+	// connections are kept indefinitely.
+	MaxConnectionLifetime time.Duration
+	// If true, each new connection is verified like Session#Handshake, and dialing fails if
+	// the node doesn't speak a supported protocol version. This includes redialing by
+	// Reconnect, health probes and Session#MaxConnectionLifetime, so a node replaced behind
+	// the same address is verified as well.
+	VerifyOnConnect bool
+	// If true, Session#Do rewrites the accounts of account pending requests to the
+	// canonical nano_ prefix. Note that the node then reports results by the nano_
+	// address. Default is false.
+	NormalizeAccounts bool
+	// Size in bytes of the operating system's receive and send buffers for tcp
+	// connections. This can improve throughput for large responses. Default is 0,
+	// which keeps the system default. Not used for local connections.
+	ReadBufferSize  int
+	WriteBufferSize int
+	// If true, responses from a node with a newer API version are accepted rather than
+	// failing with an API error, as long as the preamble is otherwise valid. This is
+	// meant for compatibility testing. Default is false.
+	AllowNewerVersion bool
+	// If set, warnings are logged here, such as when a newer API version is accepted
+	Logger *log.Logger
+	// When a tcp host resolves to both IPv6 and IPv4 addresses, the attempt to connect
+	// using the second address family starts after this delay if the first hasn't succeeded
+	// yet, and the first connection made is used. Default is 0, which means 300 milliseconds.
+	// A negative value disables the parallel attempt, so a dead address family may take
+	// up to ConnTimeout.
+	FallbackDelay time.Duration
+	// If set, frames larger than 64 KiB are read in chunks of that size, and this is called
+	// after each chunk with the bytes read so far and the frame size. This allows showing
+	// progress or spotting a stalled read before the deadline. It's called with the session
+	// lock held, so it must not make requests on the session. Default is nil, which reads
+	// frames in one go.
+	OnReadProgress func(got, total int)
+	// Encoding byte sent in the request preamble, which the node must echo in the response
+	// preamble. Default is PROTOCOL_ENCODING. Only set this to talk to experimental node
+	// builds using another encoding, as the client still marshals protobuf: a node which
+	// accepts the byte but encodes differently causes Marshalling errors or, worse, responses
+	// which unmarshal to wrong values. The preamble is fixed when dialing, so changes take
+	// effect on the next Connect or Reconnect.
+	EncodingByte byte
+	// Optional label identifying the node's role, such as "representative" or "replica".
+	// It prefixes messages logged to Session#Logger and is reported by Session#InFlight.
+	Label string
 }
 
-// Connect to a node. You can set Session#TimeoutConnection before this call, otherwise a default
+// Connect to a node. You can set Session#ConnTimeout before this call, otherwise a default
 // of 15 seconds is used.
 // connectionString is an URI of the form tcp://host:port or local:///path/to/domainsocketfile
+// It can also be a comma separated list of URIs, as passed to Session#ConnectMulti.
 func (s *Session) Connect(connectionString string) *Error {
+	return s.ConnectMulti(splitConnectionString(connectionString))
+}
+
+// Splits a comma separated list of connection strings
+func splitConnectionString(connectionString string) []string {
+	connectionStrings := strings.Split(connectionString, ",")
+	for i := range connectionStrings {
+		connectionStrings[i] = strings.TrimSpace(connectionStrings[i])
+	}
+	return connectionStrings
+}
+
+// ConnectMulti connects to the first node in connectionStrings which accepts the connection,
+// trying them in order. Redialing, such as by Session#Reconnect, tries the node which last
+// accepted the connection first, followed by the others in order.
+func (s *Session) ConnectMulti(connectionStrings []string) *Error {
+	if len(connectionStrings) == 0 {
+		return newError("No connection strings", "Connection")
+	}
+	s.connStrings = connectionStrings
+	s.connectionString = connectionStrings[0]
+	s.closed = false
+	atomic.StoreInt32(&s.draining, 0)
+	connError := s.dial(context.Background())
+	if connError == nil {
+		s.startProbe()
+	}
+	return connError
+}
+
+// ConnectDuration returns how long it took to dial the node the last time the session
+// connected, excluding the handshake
+func (s *Session) ConnectDuration() time.Duration {
+	return s.connectTime
+}
+
+// HandshakeDuration returns how long the handshake took the last time the session
+// connected. This is zero unless Session#VerifyOnConnect is set.
+func (s *Session) HandshakeDuration() time.Duration {
+	return s.handshakeTime
+}
+
+// Logs to Session#Logger, if set, prefixed by Session#Label
+func (s *Session) logf(format string, args ...interface{}) {
+	if s.Logger == nil {
+		return
+	}
+	if s.Label != "" {
+		format = "[" + s.Label + "] " + format
+	}
+	s.Logger.Printf(format, args...)
+}
+
+// RemoteAddr returns the address of the node the session last connected to, even if the
+// connection has since been closed. Returns nil if the session has never connected.
+// This method is threadsafe.
+func (s *Session) RemoteAddr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.connection == nil {
+		return nil
+	}
+	return s.connection.RemoteAddr()
+}
+
+// Handshake verifies that the node speaks a supported protocol version. As the protocol
+// has no dedicated handshake frame, this sends a ping and checks the node's preamble.
+// Protocol errors are thus reported right away rather than on first use.
+// This method is threadsafe.
+func (s *Session) Handshake() *Error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, err := s.request(&nano_api.ReqPing{}, &nano_api.ResPing{}, s.RWTimeout, false)
+	return err
+}
+
+// Reconnect closes the current connection, if any, and dials the connection string
+// previously passed to Connect. A closed or draining session is usable again afterwards.
+// This method is threadsafe.
+func (s *Session) Reconnect() *Error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.connectionString == "" {
+		return newError("Reconnect requires a prior call to Connect", "Connection")
+	}
+	if s.Connected {
+		s.Connected = false
+		s.connection.Close()
+	}
+	s.closed = false
+	atomic.StoreInt32(&s.draining, 0)
+	connError := s.dial(context.Background())
+	if connError == nil {
+		s.startProbe()
+	}
+	return connError
+}
+
+// ReconnectIfDisconnected dials the connection string previously passed to Connect if the
+// connection has been closed, such as by a failed request, and returns true if it did. A
+// connected session is left alone, so unlike Reconnect, concurrent callers sharing the
+// session don't close a connection just dialed by one another. A session which has been
+// closed with Close or is draining is not reconnected, and ErrClosed or ErrDraining is
+// returned.
+// This method is threadsafe.
+func (s *Session) ReconnectIfDisconnected() (bool, *Error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return false, ErrClosed
+	} else if atomic.LoadInt32(&s.draining) != 0 {
+		return false, ErrDraining
+	} else if s.Connected {
+		return false, nil
+	} else if s.connectionString == "" {
+		return false, newError("Reconnect requires a prior call to Connect", "Connection")
+	}
+	connError := s.dial(context.Background())
+	if connError == nil {
+		s.startProbe()
+	}
+	return connError == nil, connError
+}
+
+// Clone returns an unconnected session with the same configuration, such as timeouts,
+// rate limits and interceptors. This allows configuring a template session once and
+// cloning it for each connection of a pool. Connection state, rate limiter state and
+// byte counts are not copied.
+func (s *Session) Clone() *Session {
+	return &Session{
+		writeBuffering:          s.writeBuffering,
+		interceptors:            append([]Interceptor(nil), s.interceptors...),
+		RWTimeout:               s.RWTimeout,
+		ConnTimeout:             s.ConnTimeout,
+		TimeoutReadWrite:        s.TimeoutReadWrite,
+		TimeoutConnection:       s.TimeoutConnection,
+		ProbeInterval:           s.ProbeInterval,
+		ProbeTimeout:            s.ProbeTimeout,
+		MaxRequestBytes:         s.MaxRequestBytes,
+		MaxResponseBytes:        s.MaxResponseBytes,
+		ReadAheadSize:           s.ReadAheadSize,
+		SkipSocketCheck:         s.SkipSocketCheck,
+		RateLimits:              s.RateLimits,
+		RateLimitWait:           s.RateLimitWait,
+		ResponseBufferCap:       s.ResponseBufferCap,
+		ReturnPartialOnError:    s.ReturnPartialOnError,
+		DefaultPendingCount:     s.DefaultPendingCount,
+		DefaultPendingThreshold: s.DefaultPendingThreshold,
+		DialControl:             s.DialControl,
+		MaxConnectionLifetime:   s.MaxConnectionLifetime,
+		VerifyOnConnect:         s.VerifyOnConnect,
+		NormalizeAccounts:       s.NormalizeAccounts,
+		ReadBufferSize:          s.ReadBufferSize,
+		WriteBufferSize:         s.WriteBufferSize,
+		AllowNewerVersion:       s.AllowNewerVersion,
+		Logger:                  s.Logger,
+		FallbackDelay:           s.FallbackDelay,
+		OnReadProgress:          s.OnReadProgress,
+		EncodingByte:            s.EncodingByte,
+		Label:                   s.Label,
+	}
+}
+
+// Starts the background health probe if enabled and not already running
+func (s *Session) startProbe() {
+	if s.ProbeInterval > 0 && s.probeStop == nil {
+		if s.ProbeTimeout == 0 {
+			s.ProbeTimeout = 2 * time.Second
+		}
+		s.probeStop = make(chan struct{})
+		go s.probe(s.probeStop)
+	}
+}
+
+// Dials the node using the connection string which last succeeded, followed by the other
+// connection strings passed to Connect until one succeeds. Returns the last error if all fail.
+// Dialing stops with a Context error when ctx is done. All connecting and redialing goes
+// through here, so each new connection is verified if Session#VerifyOnConnect is set.
+func (s *Session) dial(ctx context.Context) *Error {
+	connError := s.dialVerified(ctx, s.connectionString)
+	for _, connectionString := range s.connStrings {
+		if connError == nil || ctx.Err() != nil {
+			break
+		}
+		if connectionString != s.connectionString {
+			if connError = s.dialVerified(ctx, connectionString); connError == nil {
+				s.connectionString = connectionString
+			}
+		}
+	}
+	if connError != nil && ctx.Err() != nil {
+		connError = wrapError(ctx.Err(), "Context")
+	}
+	return connError
+}
+
+// Dials the node using the given connection string and, if Session#VerifyOnConnect is
+// set, verifies the new connection with a handshake. The connection is closed if the
+// handshake fails, so the next connection string can be tried.
+func (s *Session) dialVerified(ctx context.Context, connectionString string) *Error {
+	connError := s.dialString(ctx, connectionString)
+	if connError == nil && s.VerifyOnConnect {
+		timeout := s.RWTimeout
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = time.Until(deadline)
+		}
+		handshakeStart := time.Now()
+		_, connError = s.request(&nano_api.ReqPing{}, &nano_api.ResPing{}, timeout, false)
+		s.handshakeTime = time.Since(handshakeStart)
+		if connError != nil && s.Connected {
+			s.Connected = false
+			s.connection.Close()
+		}
+	}
+	return connError
+}
+
+// Dials the node using the given connection string
+func (s *Session) dialString(ctx context.Context, connectionString string) *Error {
 	var connError *Error
 	uri, err := url.Parse(connectionString)
 	if err != nil {
-		connError = &Error{1, "Invalid connection string", "Connection"}
+		connError = &Error{1, "Invalid connection string", "Connection", nil, "", err, nil}
 	} else {
+		connError = validateConnectionURI(uri)
 		scheme := uri.Scheme
 		host := uri.Host
 		if scheme == "local" {
 			scheme = "unix"
 			host = uri.Path
-		} else if scheme != "tcp" {
-			connError = &Error{1, "Invalid schema: Use tcp or local.", "Connection"}
+			if connError == nil && !s.SkipSocketCheck {
+				if _, statErr := os.Stat(host); os.IsNotExist(statErr) {
+					connError = newError("Node socket not found at "+host+" - is the node running?", "Connection")
+				}
+			}
 		}
 
-		if s.TimeoutConnection == 0 {
-			s.TimeoutConnection = 15
+		if s.ConnTimeout == 0 {
+			s.ConnTimeout = 15 * time.Second
+			if s.TimeoutConnection != 0 {
+				s.ConnTimeout = time.Duration(s.TimeoutConnection) * time.Second
+			}
 		}
-		if s.TimeoutReadWrite == 0 {
-			s.TimeoutReadWrite = 30
+		if s.RWTimeout == 0 {
+			s.RWTimeout = 30 * time.Second
+			if s.TimeoutReadWrite != 0 {
+				s.RWTimeout = time.Duration(s.TimeoutReadWrite) * time.Second
+			}
 		}
-		dialContext := (&net.Dialer{
-			KeepAlive: 30 * time.Second,
-			Timeout:   time.Duration(s.TimeoutConnection) * time.Second,
-		}).DialContext
-
-		con, err := dialContext(context.Background(), scheme, host)
-		if err != nil {
-			connError = &Error{1, err.Error(), "Connection"}
-			s.Connected = false
-		} else {
-			s.connection = con
-			s.Connected = true
+
+		if connError == nil {
+			dialContext := (&net.Dialer{
+				KeepAlive: 30 * time.Second,
+				Timeout:   s.ConnTimeout,
+				Control:   s.DialControl,
+				// Dual-stack hosts are dialed using Happy Eyeballs
+				FallbackDelay: s.FallbackDelay,
+			}).DialContext
+
+			dialStart := time.Now()
+			con, err := dialContext(ctx, scheme, host)
+			s.connectTime = time.Since(dialStart)
+			if err != nil {
+				connError = wrapError(err, "Connection")
+				s.Connected = false
+			} else {
+				if tcpConn, ok := con.(*net.TCPConn); ok {
+					if s.ReadBufferSize > 0 {
+						tcpConn.SetReadBuffer(s.ReadBufferSize)
+					}
+					if s.WriteBufferSize > 0 {
+						tcpConn.SetWriteBuffer(s.WriteBufferSize)
+					}
+				}
+				if s.ReadAheadSize == 0 {
+					s.ReadAheadSize = 4096
+				}
+				// The preamble is constant for the lifetime of the connection
+				s.preamble = [4]byte{
+					PROTOCOL_PREAMBLE_LEAD,
+					s.EncodingByte,
+					byte(nano_api.APIVersion_VERSION_MAJOR),
+					byte(nano_api.APIVersion_VERSION_MINOR)}
+				if s.byteTotals == nil {
+					s.byteTotals = &byteTotals{}
+				}
+				counting := &countingConn{Conn: con, totals: s.byteTotals}
+				s.connection = counting
+				s.counting.Store(counting)
+				s.reader = bufio.NewReaderSize(s.connection, s.ReadAheadSize)
+				s.peekState = peekNone
+				s.versionWarned = false
+				s.writer = nil
+				if s.writeBuffering {
+					s.writer = bufio.NewWriter(s.connection)
+				}
+				s.connectedAt = time.Now()
+				s.Connected = true
+			}
 		}
 	}
 
 	return connError
 }
 
-// Close the underlying connection to the node
+// Checks that the connection string has a supported scheme and the host or path
+// appropriate for that scheme. url.Parse accepts most malformed strings, which
+// would otherwise only surface as a confusing dial error.
+func validateConnectionURI(uri *url.URL) *Error {
+	var connError *Error
+	switch uri.Scheme {
+	case "":
+		connError = newError("Missing scheme in connection string: Use tcp://host:port or local:///path", "Connection")
+	case "tcp":
+		if uri.Hostname() == "" {
+			connError = newError("Missing host in tcp connection string", "Connection")
+		} else if uri.Port() == "" {
+			connError = newError("Missing port in tcp connection string", "Connection")
+		} else if uri.Path != "" {
+			connError = newError("Unexpected path in tcp connection string", "Connection")
+		}
+	case "local":
+		if uri.Host != "" {
+			connError = newError("Local connection strings must use an absolute path, such as local:///tmp/nano", "Connection")
+		} else if uri.Path == "" {
+			connError = newError("Missing socket path in local connection string", "Connection")
+		}
+	default:
+		connError = newError("Invalid schema: Use tcp or local.", "Connection")
+	}
+	return connError
+}
+
+// Close the underlying connection to the node. A request in progress is interrupted,
+// and fails with an error matching ErrClosed.
+// This method is threadsafe.
 func (s *Session) Close() *Error {
+	// The connection is closed before taking the mutex, which a request in progress
+	// holds until it completes
+	var closeErr error
+	current := s.countingConn()
+	if current != nil {
+		closeErr = current.Close()
+	}
+
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
 	var err *Error
+	s.closed = true
+	if s.probeStop != nil {
+		close(s.probeStop)
+		s.probeStop = nil
+	}
 	if s.Connected {
 		s.Connected = false
-		closeErr := s.connection.Close()
+		// The connection may have been redialed in the meantime
+		if s.connection != net.Conn(current) {
+			closeErr = s.connection.Close()
+		}
 		if closeErr != nil {
-			err = &Error{1, closeErr.Error(), "Connection"}
+			err = wrapError(closeErr, "Connection")
 		}
 	}
 	return err
 }
 
-// Updates the write deadline using Session#TimeoutReadWrite
-func (s *Session) updateWriteDeadline() {
-	s.connection.SetWriteDeadline(time.Now().Add(time.Duration(s.TimeoutReadWrite) * time.Second))
+// Drain stops the session from sending new requests, which fail with ErrDraining, waits
+// for the request in progress, if any, to complete and closes the session. If ctx is done
+// first, a Context error is returned and the session is left draining. Connect or Reconnect
+// makes the session usable again.
+// This method is threadsafe.
+func (s *Session) Drain(ctx context.Context) *Error {
+	atomic.StoreInt32(&s.draining, 1)
+
+	idle := make(chan struct{})
+	go func() {
+		s.mutex.Lock()
+		s.mutex.Unlock()
+		close(idle)
+	}()
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		return wrapError(ctx.Err(), "Context")
+	}
+	return s.Close()
 }
 
-// Updates the read deadline using Session#TimeoutReadWrite
-func (s *Session) updateReadDeadline() {
-	s.connection.SetReadDeadline(time.Now().Add(time.Duration(s.TimeoutReadWrite) * time.Second))
+// Periodically pings the node until stop is closed. On failure, the connection
+// is closed and redialed.
+func (s *Session) probe(stop chan struct{}) {
+	ticker := time.NewTicker(s.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if atomic.LoadInt32(&s.draining) != 0 {
+				// Don't redial a session which is about to close
+				continue
+			}
+			s.mutex.Lock()
+			if s.peekState != peekNone {
+				// The connection is in use by SendRequest until the response is read
+				s.mutex.Unlock()
+				continue
+			}
+			if _, err := s.request(&nano_api.ReqPing{}, &nano_api.ResPing{}, s.ProbeTimeout, false); err != nil {
+				select {
+				case <-stop:
+					// Closed while probing, don't fight the close
+				default:
+					if s.Connected {
+						s.Connected = false
+						s.connection.Close()
+					}
+					s.dial(context.Background())
+				}
+			}
+			s.mutex.Unlock()
+		}
+	}
+}
+
+// Updates the write deadline using the given timeout
+func (s *Session) updateWriteDeadline(timeout time.Duration) {
+	s.connection.SetWriteDeadline(time.Now().Add(timeout))
+}
+
+// Updates the read deadline using the given timeout
+func (s *Session) updateReadDeadline(timeout time.Duration) {
+	s.connection.SetReadDeadline(time.Now().Add(timeout))
+}
+
+// Returns a buffer of length n for reading a frame, reusing the session buffer if
+// the frame is within Session#ResponseBufferCap. The buffer is only valid until the
+// next read.
+func (s *Session) readBuffer(n int) []byte {
+	if s.ResponseBufferCap == 0 {
+		s.ResponseBufferCap = 1 << 20
+	}
+	if cap(s.buffer) > s.ResponseBufferCap {
+		// The cap was lowered since the buffer grew
+		s.buffer = nil
+	}
+	if n > s.ResponseBufferCap {
+		return make([]byte, n)
+	}
+	if n > cap(s.buffer) {
+		s.buffer = make([]byte, n)
+	}
+	return s.buffer[:n]
+}
+
+// Returns the writer for outbound frames, which is buffered if write buffering is on
+func (s *Session) output() io.Writer {
+	if s.writer != nil {
+		return s.writer
+	}
+	return s.connection
+}
+
+// SetWriteBuffering turns buffering of outbound frames on or off. When on, the preamble
+// and frames of a request are accumulated and sent in a single write once the response
+// must be read. As each request waits for its response, frames are never held back
+// across requests. Note that buffering delays write errors until the request is sent.
+func (s *Session) SetWriteBuffering(on bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Requests flush their frames, so the buffer is empty between requests
+	s.writeBuffering = on
+	s.writer = nil
+	if on && s.Connected {
+		s.writer = bufio.NewWriter(s.connection)
+	}
+}
+
+// Translates a read error, distinguishing a connection closed by the node from other network
+// errors. The returned error matches ErrPeerClosed with errors.Is, and wraps err.
+func readError(err error) *Error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrPeerClosed.withCause(err)
+	}
+	return writeError(err)
+}
+
+// Translates a write error. Using a connection closed on our side, such as by a concurrent
+// Close, fails with an error matching ErrClosed rather than the "use of closed network
+// connection" error, which is kept as the cause.
+func writeError(err error) *Error {
+	if errors.Is(err, net.ErrClosed) {
+		return ErrClosed.withCause(err)
+	}
+	return wrapError(err, "Network")
 }
 
 // A CallChain allows safe chaining of functions. If an error
@@ -143,123 +798,254 @@ func (sc *CallChain) failure(fn func()) *CallChain {
 // This method is threadsafe.
 // The response output argument will contain the result if no error is returned.
 func (s *Session) Request(request proto.Message, response proto.Message) *Error {
+	return s.intercept(func(request proto.Message, response proto.Message) *Error {
+		return s.send(context.Background(), &call{request: request, response: response})
+	})(request, response)
+}
+
+// RequestTimeout works like Request, but uses the given read and write timeout
+// instead of Session#RWTimeout. This is useful for request types which are slower
+// than usual. A timeout of zero uses Session#RWTimeout.
+func (s *Session) RequestTimeout(request proto.Message, response proto.Message, timeout time.Duration) *Error {
+	return s.intercept(func(request proto.Message, response proto.Message) *Error {
+		return s.send(context.Background(), &call{request: request, response: response, timeout: timeout})
+	})(request, response)
+}
+
+// RequestContext works like Request, but the request is aborted with a Context error
+// when ctx is cancelled. If ctx has a deadline, it is used instead of Session#RWTimeout,
+// and bounds the entire request including rate limiting and redialing.
+// As the response can't be read after an aborted request, the connection is closed
+// and must be reconnected.
+func (s *Session) RequestContext(ctx context.Context, request proto.Message, response proto.Message) *Error {
+	return s.intercept(func(request proto.Message, response proto.Message) *Error {
+		return s.send(ctx, &call{request: request, response: response})
+	})(request, response)
+}
+
+// RequestRawResponse works like Request, but also returns the raw response body as received
+// from the node. This is useful for caching and auditing node replies.
+// The returned slice is a copy owned by the caller. If the body fails to unmarshal and
+// Session#ReturnPartialOnError is set, the raw body is returned along with the error.
+func (s *Session) RequestRawResponse(request proto.Message, response proto.Message) ([]byte, *Error) {
+	var raw []byte
+	err := s.intercept(func(request proto.Message, response proto.Message) *Error {
+		c := &call{request: request, response: response, copyBody: true}
+		err := s.send(context.Background(), c)
+		raw = c.body
+		return err
+	})(request, response)
+	return raw, err
+}
+
+// RequestTyped works like Request, but fails with a Marshalling error if the type declared
+// in the response header doesn't match the response message. This catches decoding a body
+// into the wrong message type, such as after a frame desync.
+func (s *Session) RequestTyped(request proto.Message, response proto.Message) *Error {
+	return s.intercept(func(request proto.Message, response proto.Message) *Error {
+		return s.send(context.Background(), &call{request: request, response: response, strictType: true})
+	})(request, response)
+}
+
+// A request sent by Session#send, along with the options and results of the request method
+type call struct {
+	request  proto.Message
+	response proto.Message
+	// Read and write timeout. Default is Session#RWTimeout.
+	timeout time.Duration
+	// If true, the type declared by the response header must match the response
+	strictType bool
+	// If true, body is set to a copy of the response body
+	copyBody bool
+	body     []byte
+	// The response header, or nil if none was received
+	header *nano_api.Response
+	// True if the connection was redialed for the request
+	reconnected bool
+}
+
+// Throttles and sends the request of the call, which all request methods go through. The
+// request is aborted with a Context error when ctx is cancelled. If ctx has a deadline, it's
+// used instead of the timeout of the call, and bounds the entire request including rate
+// limiting and redialing. As the response can't be read after an aborted request, the
+// connection is then closed.
+func (s *Session) send(ctx context.Context, c *call) *Error {
+
+	if err := s.throttle(ctx, c.request); err != nil {
+		return err
+	}
 
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	// Protobuf encoding
-	const PROTOCOL_ENCODING = 0
-	const PROTOCOL_PREAMBLE_LEAD = 'N'
+	if ctx.Err() != nil {
+		return wrapError(ctx.Err(), "Context")
+	}
 
-	var err *Error
-	if !s.Connected {
-		err = &Error{1, "Not connected", "Network"}
+	// Renew an aged connection first, as the connection is captured below. The
+	// deadline of ctx covers redialing as well as the request.
+	connectedAt := s.connectedAt
+	if err := s.renewAgedConnection(ctx); err != nil {
+		return err
+	}
+	timeout := c.timeout
+	if timeout == 0 {
+		timeout = s.RWTimeout
+	}
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		timeout = time.Until(deadline)
+	}
+
+	// Interrupt blocking reads and writes when the context is done
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	if s.Connected && ctx.Done() != nil {
+		connection := s.connection
+		go func() {
+			defer close(stopped)
+			select {
+			case <-ctx.Done():
+				connection.SetDeadline(time.Now())
+			case <-done:
+			}
+		}()
 	} else {
-		sc := &CallChain{}
+		close(stopped)
+	}
 
-		var err error
-		var preamble [4]byte
-		var bufLen [4]byte
-		var msgBuffer []byte
-		var bufResponseHeader []byte
-		var bufResponse []byte
-		var headerData []byte
+	body, err := s.request(c.request, c.response, timeout, c.strictType)
+	close(done)
+	<-stopped
 
-		requestType := strings.ToUpper(strings.Replace(proto.MessageName(request), "nano.api.req_", "", 1))
-		requestHeader := &nano_api.Request{
-			Type: nano_api.RequestType(nano_api.RequestType_value[requestType]),
+	c.header = s.header
+	c.reconnected = s.connectedAt != connectedAt
+	if c.copyBody && (err == nil || body != nil) {
+		// The body buffer is reused by the next request
+		c.body = make([]byte, len(body))
+		copy(c.body, body)
+	}
+	// A read or write timing out at the deadline may be seen before ctx is done
+	if err != nil && (ctx.Err() != nil || hasDeadline && !time.Now().Before(deadline)) {
+		if s.Connected {
+			s.Connected = false
+			s.connection.Close()
 		}
-
-		if requestHeader.Type == nano_api.RequestType_INVALID {
-			panic("Invalid request type:" + requestType)
+		ctxErr := ctx.Err()
+		if ctxErr == nil {
+			ctxErr = context.DeadlineExceeded
 		}
+		err = wrapError(ctxErr, "Context")
+	}
+	return err
+}
+
+// Closes and redials the connection if it's older than Session#MaxConnectionLifetime,
+// before the node forcibly closes it. A draining session isn't redialed.
+// The caller must hold the session mutex.
+func (s *Session) renewAgedConnection(ctx context.Context) *Error {
+	var err *Error
+	if s.Connected && s.MaxConnectionLifetime > 0 && time.Since(s.connectedAt) > s.MaxConnectionLifetime &&
+		atomic.LoadInt32(&s.draining) == 0 {
+		s.Connected = false
+		s.connection.Close()
+		err = s.dial(ctx)
+	}
+	return err
+}
+
+// Returns the type declared by the response header, or an empty string if none
+func responseType(header *nano_api.Response) string {
+	if header.Type == nano_api.RequestType_INVALID {
+		return ""
+	}
+	return header.Type.String()
+}
+
+// Sends the request using the given read and write timeout and returns the response body.
+// If strictType is set, the type declared by the response header must match the response.
+// On error, the body is nil and the response is reset, unless the body failed to unmarshal
+// and Session#ReturnPartialOnError is set. If writing the request or reading the response
+// fails, such as on a timeout, the connection is closed.
+// The caller must hold the session mutex.
+func (s *Session) request(request proto.Message, response proto.Message, timeout time.Duration, strictType bool) ([]byte, *Error) {
+
+	var reqErr *Error
+	var bufResponse []byte
+	if s.closed {
+		reqErr = ErrClosed
+	} else if atomic.LoadInt32(&s.draining) != 0 {
+		reqErr = ErrDraining
+	} else if !s.Connected {
+		reqErr = newError("Not connected", "Network")
+	} else if s.peekState != peekNone {
+		reqErr = ErrPeekPending
+	}
+	s.header = nil
+	if reqErr == nil {
+		defer s.trackInFlight(request)()
+		sc := &CallChain{}
+
+		var err error
+		var bufResponseHeader []byte
+		var partial bool
+		var headerErr *Error
+		// True while the response has been requested but not read completely
+		var pending bool
 
 		sc.do(func() {
-			preamble = [4]byte{
-				PROTOCOL_PREAMBLE_LEAD,
-				PROTOCOL_ENCODING,
-				byte(nano_api.APIVersion_VERSION_MAJOR),
-				byte(nano_api.APIVersion_VERSION_MINOR)}
-			s.updateWriteDeadline()
-			if _, err = s.connection.Write(preamble[:]); err != nil {
-				sc.err = &Error{1, err.Error(), "Network"}
-			}
-		}).do(func() {
-			if headerData, err = proto.Marshal(requestHeader); err != nil {
-				sc.err = &Error{1, err.Error(), "Marshalling"}
-			}
-		}).do(func() {
-			binary.BigEndian.PutUint32(bufLen[:], uint32(len(headerData)))
-			s.updateWriteDeadline()
-			if _, err = s.connection.Write(bufLen[:]); err != nil {
-				sc.err = &Error{1, err.Error(), "Network"}
-			}
-		}).do(func() {
-			s.updateWriteDeadline()
-			if _, err = s.connection.Write(headerData[:]); err != nil {
-				sc.err = &Error{1, err.Error(), "Network"}
-			}
-		}).do(func() {
-			if msgBuffer, err = proto.Marshal(request); err != nil {
-				sc.err = &Error{1, err.Error(), "Marshalling"}
-			}
-		}).do(func() {
-			binary.BigEndian.PutUint32(bufLen[:], uint32(len(msgBuffer)))
-			s.updateWriteDeadline()
-			if _, err = s.connection.Write(bufLen[:]); err != nil {
-				sc.err = &Error{1, err.Error(), "Network"}
-			}
-		}).do(func() {
-			s.updateWriteDeadline()
-			if _, err = s.connection.Write(msgBuffer[:]); err != nil {
-				sc.err = &Error{1, err.Error(), "Network"}
-			}
+			sc.err = s.writeRequest(request, timeout)
+			pending = sc.err == nil
 		}).do(func() {
-			// Read and verify preamble
-			s.updateReadDeadline()
-			if _, err = io.ReadFull(s.connection, preamble[:]); err != nil {
-				sc.err = &Error{1, err.Error(), "Network"}
-			} else {
-				if preamble[0] != PROTOCOL_PREAMBLE_LEAD || preamble[1] != PROTOCOL_ENCODING {
-					sc.err = &Error{1, "Invalid preamble", "Network"}
-				} else if preamble[2] > 1 {
-					sc.err = &Error{1, "Unsupported API version", "API"}
-				}
-			}
+			sc.err = s.readPreamble(timeout)
 		}).do(func() {
-			s.updateReadDeadline()
-			if _, err = io.ReadFull(s.connection, bufLen[:]); err != nil {
-				sc.err = &Error{1, err.Error(), "Network"}
-			}
-		}).do(func() {
-			bufResponseHeader = make([]byte, binary.BigEndian.Uint32(bufLen[:]))
-			s.updateReadDeadline()
-			if _, err = io.ReadFull(s.connection, bufResponseHeader); err != nil {
-				sc.err = &Error{1, err.Error(), "Network"}
-			}
+			bufResponseHeader, sc.err = s.readFrame(timeout)
 		}).do(func() {
+			// Errors found in the header are reported once the body frame is read, so the
+			// next request isn't out of sync
 			respHeader := &nano_api.Response{}
+			s.header = respHeader
 			if err = proto.Unmarshal(bufResponseHeader, respHeader); err != nil {
-				sc.err = &Error{1, err.Error(), "Marshalling"}
+				headerErr = wrapError(err, "Marshalling")
+			} else if respHeader.ErrorCode != 0 {
+				headerErr = &Error{int(respHeader.ErrorCode), respHeader.ErrorMessage, respHeader.ErrorCategory, nil, "", nil, nil}
+				if respHeader.ErrorCategory == NodeBusyCategory {
+					headerErr.cause = ErrNodeBusy
+				}
+			} else if strictType && respHeader.Type != nano_api.RequestTypeForResponse(proto.MessageName(response)) {
+				headerErr = newError(fmt.Sprintf("Response type %s does not match %s", respHeader.Type, proto.MessageName(response)), "Marshalling")
 			}
-		}).do(func() {
-			s.updateReadDeadline()
-			if _, err = io.ReadFull(s.connection, bufLen[:]); err != nil {
-				sc.err = &Error{1, err.Error(), "Network"}
+			if headerErr != nil && err == nil {
+				headerErr.ResponseType = responseType(respHeader)
 			}
 		}).do(func() {
-			bufResponse = make([]byte, binary.BigEndian.Uint32(bufLen[:]))
-			s.updateReadDeadline()
-			if _, err = io.ReadFull(s.connection, bufResponse); err != nil {
-				sc.err = &Error{1, err.Error(), "Network"}
-			}
+			bufResponse, sc.err = s.readFrame(timeout)
+			pending = sc.err != nil
 		}).do(func() {
-			if err = proto.Unmarshal(bufResponse, response); err != nil {
-				sc.err = &Error{1, err.Error(), "Marshalling"}
+			if headerErr != nil {
+				sc.err = headerErr
+			} else if err = proto.Unmarshal(bufResponse, response); err != nil {
+				sc.err = wrapError(err, "Marshalling")
+				sc.err.ResponseType = responseType(s.header)
+				partial = s.ReturnPartialOnError
+				if partial {
+					// The body buffer is reused, so the error gets a copy
+					sc.err.Raw = append([]byte(nil), bufResponse...)
+				}
 			}
 		}).failure(func() {
-			err = sc.err
+			// The rest of a partially read response, such as after a read timeout, can't
+			// be told apart from the next response. Reconnect makes the session usable again.
+			if pending && s.Connected {
+				s.Connected = false
+				s.connection.Close()
+			}
+			if !partial {
+				response.Reset()
+				bufResponse = nil
+			}
+			reqErr = sc.err
 		})
 	}
-	return err
+	return bufResponse, reqErr
 }
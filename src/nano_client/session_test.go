@@ -0,0 +1,426 @@
+package nano_client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"nano_api"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/time/rate"
+)
+
+func TestValidateConnectionURI(t *testing.T) {
+	tests := []struct {
+		connectionString string
+		valid            bool
+	}{
+		{"tcp://localhost:7077", true},
+		{"tcp://127.0.0.1:7077", true},
+		{"tcp://[::1]:7077", true},
+		{"local:///tmp/nano", true},
+		{"tcp//localhost:7077", false},
+		{"localhost:7077", false},
+		{"tcp://localhost", false},
+		{"tcp://:7077", false},
+		{"tcp://localhost:7077/api", false},
+		{"local://tmp/nano", false},
+		{"local://", false},
+		{"udp://localhost:7077", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		uri, err := url.Parse(test.connectionString)
+		if err != nil {
+			if test.valid {
+				t.Errorf("%q: unexpected parse error: %v", test.connectionString, err)
+			}
+			continue
+		}
+		connErr := validateConnectionURI(uri)
+		if test.valid && connErr != nil {
+			t.Errorf("%q: unexpected error: %v", test.connectionString, connErr)
+		} else if !test.valid && connErr == nil {
+			t.Errorf("%q: expected an error", test.connectionString)
+		} else if connErr != nil && connErr.Category != "Connection" {
+			t.Errorf("%q: expected a Connection error, got %v", test.connectionString, connErr)
+		}
+	}
+}
+
+func TestReadErrorKeepsCause(t *testing.T) {
+	for _, cause := range []error{io.EOF, io.ErrUnexpectedEOF} {
+		err := readError(cause)
+		if !errors.Is(err, ErrPeerClosed) {
+			t.Errorf("%v: expected ErrPeerClosed, got %v", cause, err)
+		}
+		if !errors.Is(err, cause) {
+			t.Errorf("%v: cause lost in %v", cause, err)
+		}
+	}
+	if errors.Is(newError("Connection closed by node", "Connection"), ErrPeerClosed) {
+		t.Error("an error equal to ErrPeerClosed must not match it")
+	}
+}
+
+func TestWriteErrorKeepsCause(t *testing.T) {
+	cause := &net.OpError{Op: "write", Net: "unix", Err: net.ErrClosed}
+	err := writeError(cause)
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		t.Errorf("cause lost in %v", err)
+	}
+}
+
+func TestRequestAfterBusyResponse(t *testing.T) {
+	requests := 0
+	node := newFakeNode(t, func(conn net.Conn, header *nano_api.Request, body []byte) {
+		requests++
+		if requests == 1 {
+			busy := &nano_api.Response{ErrorCode: 4, ErrorMessage: "Too many requests", ErrorCategory: NodeBusyCategory}
+			conn.Write(testResponse(busy, &nano_api.ResPing{}))
+			return
+		}
+		pingHandler(conn, header, body)
+	})
+	s := node.connect(t)
+
+	err := s.Request(&nano_api.ReqPing{Id: 1}, &nano_api.ResPing{})
+	if !errors.Is(err, ErrNodeBusy) {
+		t.Fatalf("expected ErrNodeBusy, got %v", err)
+	}
+	if err.Code != 4 || err.Message != "Too many requests" {
+		t.Errorf("node error not kept: %v", err)
+	}
+
+	// The body sent along with the error must not be taken for the next response
+	response := &nano_api.ResPing{}
+	if err := s.Request(&nano_api.ReqPing{Id: 2}, response); err != nil {
+		t.Fatalf("request after busy response failed: %v", err)
+	}
+	if response.Id != 2 {
+		t.Errorf("expected id 2, got %d", response.Id)
+	}
+}
+
+func TestCloseDuringRequest(t *testing.T) {
+	// The node never responds, so the request waits until the session is closed
+	node := newFakeNode(t, func(conn net.Conn, header *nano_api.Request, body []byte) {})
+	s := node.connect(t)
+	s.RWTimeout = 10 * time.Second
+
+	result := make(chan *Error, 1)
+	go func() {
+		result <- s.Request(&nano_api.ReqPing{}, &nano_api.ResPing{})
+	}()
+	for len(s.InFlight()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	start := time.Now()
+	if err := s.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	if err := <-result; !errors.Is(err, ErrClosed) || errors.Is(err, ErrPeerClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("close took %v to interrupt the request", elapsed)
+	}
+	if err := s.Request(&nano_api.ReqPing{}, &nano_api.ResPing{}); err != ErrClosed {
+		t.Errorf("expected ErrClosed after closing, got %v", err)
+	}
+}
+
+func TestRequestMethods(t *testing.T) {
+	node := newFakeNode(t, pingHandler)
+	s := node.connect(t)
+
+	methods := map[string]func(request *nano_api.ReqPing, response *nano_api.ResPing) *Error{
+		"Request": func(request *nano_api.ReqPing, response *nano_api.ResPing) *Error {
+			return s.Request(request, response)
+		},
+		"RequestTimeout": func(request *nano_api.ReqPing, response *nano_api.ResPing) *Error {
+			return s.RequestTimeout(request, response, time.Second)
+		},
+		"RequestContext": func(request *nano_api.ReqPing, response *nano_api.ResPing) *Error {
+			return s.RequestContext(context.Background(), request, response)
+		},
+		"RequestTyped": func(request *nano_api.ReqPing, response *nano_api.ResPing) *Error {
+			return s.RequestTyped(request, response)
+		},
+		"RequestRawResponse": func(request *nano_api.ReqPing, response *nano_api.ResPing) *Error {
+			raw, err := s.RequestRawResponse(request, response)
+			if err == nil && raw == nil {
+				t.Error("RequestRawResponse: no raw response")
+			}
+			return err
+		},
+		"requestResult": func(request *nano_api.ReqPing, response *nano_api.ResPing) *Error {
+			result, err := requestResult(s, request, response)
+			if err == nil && result.Header == nil {
+				t.Error("requestResult: no response header")
+			}
+			return err
+		},
+	}
+	id := uint32(0)
+	for name, method := range methods {
+		id++
+		response := &nano_api.ResPing{}
+		if err := method(&nano_api.ReqPing{Id: id}, response); err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if response.Id != id {
+			t.Errorf("%s: expected id %d, got %d", name, id, response.Id)
+		}
+	}
+}
+
+func TestResponseExceedingMaxResponseBytes(t *testing.T) {
+	node := newFakeNode(t, func(conn net.Conn, header *nano_api.Request, body []byte) {
+		conn.Write(testResponse(&nano_api.Response{Type: header.Type}, &nano_api.ResPing{Id: 1 << 30}))
+	})
+	s := node.connect(t)
+	s.MaxResponseBytes = 2
+
+	err := s.Request(&nano_api.ReqPing{}, &nano_api.ResPing{})
+	if err == nil || err.Category != "Network" {
+		t.Fatalf("expected a Network error, got %v", err)
+	}
+	if s.Connected {
+		t.Error("expected the connection to be closed")
+	}
+}
+
+func TestReconnectAfterDrain(t *testing.T) {
+	node := newFakeNode(t, pingHandler)
+	s := node.connect(t)
+
+	if err := s.Drain(context.Background()); err != nil {
+		t.Fatalf("drain failed: %v", err)
+	}
+	if err := s.Request(&nano_api.ReqPing{}, &nano_api.ResPing{}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed after draining, got %v", err)
+	}
+	if err := s.Reconnect(); err != nil {
+		t.Fatalf("reconnect failed: %v", err)
+	}
+	response := &nano_api.ResPing{}
+	if err := s.Request(&nano_api.ReqPing{Id: 1}, response); err != nil {
+		t.Fatalf("request after reconnect failed: %v", err)
+	}
+	if response.Id != 1 {
+		t.Errorf("expected id 1, got %d", response.Id)
+	}
+}
+
+// Returns the error of a RequestContext with the given timeout, failing the test if it
+// doesn't return soon after the deadline
+func requestWithDeadline(t *testing.T, s *Session, timeout time.Duration) *Error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	err := s.RequestContext(ctx, &nano_api.ReqPing{}, &nano_api.ResPing{})
+	if elapsed := time.Since(start); elapsed > timeout+time.Second {
+		t.Errorf("request took %v, exceeding the deadline of %v", elapsed, timeout)
+	}
+	return err
+}
+
+func TestRequestContextDeadlineAfterRedial(t *testing.T) {
+	// The node never responds, so only the deadline ends the request
+	node := newFakeNode(t, func(conn net.Conn, header *nano_api.Request, body []byte) {})
+	s := node.connect(t)
+	s.RWTimeout = 10 * time.Second
+	// Forces a redial before the request
+	s.MaxConnectionLifetime = time.Nanosecond
+
+	if err := requestWithDeadline(t, s, 200*time.Millisecond); err == nil || err.Category != "Context" {
+		t.Fatalf("expected a Context error, got %v", err)
+	}
+}
+
+func TestRequestContextDeadlineWhileRateLimited(t *testing.T) {
+	node := newFakeNode(t, pingHandler)
+	s := node.connect(t)
+	s.RateLimits = map[nano_api.RequestType]rate.Limit{nano_api.RequestType_PING: 0.1}
+	s.RateLimitWait = true
+
+	// Uses up the burst, so the next request would wait ten seconds
+	if err := s.Request(&nano_api.ReqPing{}, &nano_api.ResPing{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := requestWithDeadline(t, s, 200*time.Millisecond); err == nil {
+		t.Fatal("expected the rate limited request to fail")
+	}
+}
+
+func TestErrorResponseType(t *testing.T) {
+	node := newFakeNode(t, func(conn net.Conn, header *nano_api.Request, body []byte) {
+		ping := &nano_api.ReqPing{}
+		proto.Unmarshal(body, ping)
+		// The id selects the declared response type
+		responseHeader := &nano_api.Response{ErrorCode: 2, ErrorMessage: "error", ErrorCategory: "error_common"}
+		if ping.Id == 1 {
+			responseHeader.Type = header.Type
+		}
+		conn.Write(testResponse(responseHeader, &nano_api.ResPing{}))
+	})
+	s := node.connect(t)
+
+	if err := s.Request(&nano_api.ReqPing{Id: 1}, &nano_api.ResPing{}); err == nil || err.ResponseType != "PING" {
+		t.Errorf("expected an error with response type PING, got %+v", err)
+	}
+	if err := s.Request(&nano_api.ReqPing{Id: 2}, &nano_api.ResPing{}); err == nil || err.ResponseType != "" {
+		t.Errorf("expected an error without response type, got %+v", err)
+	}
+}
+
+func TestEncodingByteChangedWhileConnected(t *testing.T) {
+	node := newFakeNode(t, pingHandler)
+	s := node.connect(t)
+
+	// The connection keeps the encoding it was dialed with
+	s.EncodingByte = 1
+	if err := s.Request(&nano_api.ReqPing{}, &nano_api.ResPing{}); err != nil {
+		t.Fatalf("request after changing the encoding byte failed: %v", err)
+	}
+}
+
+func TestRequestAfterTimeoutMidResponse(t *testing.T) {
+	tests := []struct {
+		name  string
+		split int
+	}{
+		{"response delayed", 0},
+		{"response partially written", 10},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Only the first response is faulty
+			var requests int32
+			faulty := withFault(pingHandler, test.split, 200*time.Millisecond)
+			node := newFakeNode(t, func(conn net.Conn, header *nano_api.Request, body []byte) {
+				if atomic.AddInt32(&requests, 1) == 1 {
+					faulty(conn, header, body)
+				} else {
+					pingHandler(conn, header, body)
+				}
+			})
+			s := node.connect(t)
+			s.RWTimeout = 50 * time.Millisecond
+
+			if err := s.Request(&nano_api.ReqPing{Id: 1}, &nano_api.ResPing{}); err == nil {
+				t.Fatal("expected the first request to time out")
+			}
+			// Let the node finish writing the response of the timed out request
+			time.Sleep(300 * time.Millisecond)
+
+			// The stale response must not be taken for the response of the next request
+			response := &nano_api.ResPing{}
+			if err := s.Request(&nano_api.ReqPing{Id: 2}, response); err == nil {
+				t.Fatalf("expected the request to fail on the desynced connection, got id %d", response.Id)
+			}
+			if err := s.Reconnect(); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Request(&nano_api.ReqPing{Id: 3}, response); err != nil || response.Id != 3 {
+				t.Fatalf("expected id 3 after reconnecting, got id %d, error %v", response.Id, err)
+			}
+		})
+	}
+}
+
+func TestReconnectIfDisconnected(t *testing.T) {
+	node := newFakeNode(t, func(conn net.Conn, header *nano_api.Request, body []byte) {
+		conn.Close()
+	})
+	s := node.connect(t)
+
+	if reconnected, err := s.ReconnectIfDisconnected(); reconnected || err != nil {
+		t.Fatalf("expected a connected session to be left alone, got %v, %v", reconnected, err)
+	}
+	if err := s.Request(&nano_api.ReqPing{Id: 1}, &nano_api.ResPing{}); !errors.Is(err, ErrPeerClosed) {
+		t.Fatalf("expected ErrPeerClosed, got %v", err)
+	}
+
+	// Only one of the concurrent callers redials
+	var reconnects int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reconnected, err := s.ReconnectIfDisconnected()
+			if err != nil {
+				t.Error(err)
+			} else if reconnected {
+				atomic.AddInt32(&reconnects, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if reconnects != 1 || !s.Connected {
+		t.Errorf("expected a single reconnect, got %d", reconnects)
+	}
+
+	s.Close()
+	if _, err := s.ReconnectIfDisconnected(); err != ErrClosed {
+		t.Errorf("expected ErrClosed for a closed session, got %v", err)
+	}
+}
+
+func TestVerifyOnRedial(t *testing.T) {
+	// Each connection keeps the version the node spoke when it was accepted
+	supported := int32(nano_api.APIVersion_VERSION_MAJOR)
+	major := supported
+	var versions sync.Map
+	node := newFakeNode(t, func(conn net.Conn, header *nano_api.Request, body []byte) {
+		version, _ := versions.LoadOrStore(conn, atomic.LoadInt32(&major))
+		ping := &nano_api.ReqPing{}
+		proto.Unmarshal(body, ping)
+		response := testResponse(&nano_api.Response{Type: header.Type}, &nano_api.ResPing{Id: ping.Id})
+		response[2] = byte(version.(int32))
+		conn.Write(response)
+	})
+	s := &Session{VerifyOnConnect: true}
+	if err := s.Connect(node.connectionString()); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.HandshakeDuration() == 0 {
+		t.Error("expected a handshake on connect")
+	}
+
+	// The node is replaced by one speaking an unsupported version
+	atomic.StoreInt32(&major, supported+1)
+	if err := s.Reconnect(); err == nil || err.Category != "API" {
+		t.Fatalf("expected Reconnect to fail with an API error, got %v", err)
+	}
+	if s.Connected {
+		t.Error("expected the unverified connection to be closed")
+	}
+
+	// Renewing an aged connection verifies the new connection as well
+	atomic.StoreInt32(&major, supported)
+	if err := s.Reconnect(); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&major, supported+1)
+	if err := s.Request(&nano_api.ReqPing{}, &nano_api.ResPing{}); err != nil {
+		t.Fatalf("request on the verified connection failed: %v", err)
+	}
+	s.MaxConnectionLifetime = time.Nanosecond
+	if err := s.Request(&nano_api.ReqPing{}, &nano_api.ResPing{}); err == nil || err.Category != "API" {
+		t.Fatalf("expected the redialed request to fail with an API error, got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package nano_client
+
+import (
+	"context"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// SingleFlight wraps a Requester so that identical concurrent requests share a single
+// node round trip. Requests are identical if they have the same type and marshal to
+// the same bytes. This is meant for read-only queries, such as account pending.
+type SingleFlight struct {
+	mutex     sync.Mutex
+	requester Requester
+	calls     map[string]*flight
+}
+
+// An in-flight request whose result is shared by all callers
+type flight struct {
+	done     chan struct{}
+	response proto.Message
+	err      *Error
+}
+
+// NewSingleFlight returns a requester coalescing identical in-flight requests sent through r
+func NewSingleFlight(r Requester) *SingleFlight {
+	return &SingleFlight{requester: r, calls: make(map[string]*flight)}
+}
+
+// Request sends the request through the wrapped Requester, unless an identical request
+// is in flight, in which case its result is copied into the response.
+// This method is threadsafe.
+func (sf *SingleFlight) Request(request proto.Message, response proto.Message) *Error {
+	return sf.do(context.Background(), request, response)
+}
+
+// RequestContext works like Request, but stops waiting for the result with a Context error
+// when ctx is cancelled. As the request is shared, it isn't cancelled along with ctx, and
+// completes for the other callers waiting for it.
+// This method is threadsafe.
+func (sf *SingleFlight) RequestContext(ctx context.Context, request proto.Message, response proto.Message) *Error {
+	return sf.do(ctx, request, response)
+}
+
+// Close closes the wrapped Requester
+func (sf *SingleFlight) Close() *Error {
+	return sf.requester.Close()
+}
+
+// Sends the request unless an identical request is in flight, and copies the result into
+// response. The request is sent in the background, so that no caller can cancel it for
+// the others, and each caller only stops waiting when its own ctx is done.
+func (sf *SingleFlight) do(ctx context.Context, request proto.Message, response proto.Message) *Error {
+	if err := checkMessages(request, response); err != nil {
+		return err
+	}
+	data, err := proto.Marshal(request)
+	if err != nil {
+		return wrapError(err, "Marshalling")
+	}
+	key := proto.MessageName(request) + ":" + string(data)
+
+	sf.mutex.Lock()
+	call, ok := sf.calls[key]
+	if !ok {
+		// The shared messages are separate from the caller's, which the caller may modify
+		call = &flight{done: make(chan struct{}), response: proto.Clone(response)}
+		call.response.Reset()
+		sf.calls[key] = call
+		go sf.send(key, call, proto.Clone(request))
+	}
+	sf.mutex.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return wrapError(ctx.Err(), "Context")
+	}
+	if call.err == nil {
+		response.Reset()
+		proto.Merge(response, call.response)
+	}
+	return call.err
+}
+
+// Sends the shared request of call, and completes it for all callers
+func (sf *SingleFlight) send(key string, call *flight, request proto.Message) {
+	call.err = sf.requester.Request(request, call.response)
+
+	sf.mutex.Lock()
+	delete(sf.calls, key)
+	sf.mutex.Unlock()
+	close(call.done)
+}
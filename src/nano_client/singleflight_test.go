@@ -0,0 +1,45 @@
+package nano_client
+
+import (
+	"context"
+	"nano_api"
+	"testing"
+	"time"
+)
+
+func TestSingleFlightCancelOnlyStopsWaiting(t *testing.T) {
+	r := newBlockingRequester()
+	sf := NewSingleFlight(r)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan *Error, 1)
+	go func() {
+		first <- sf.RequestContext(ctx, &nano_api.ReqPing{Id: 1}, &nano_api.ResPing{})
+	}()
+	reply := <-r.calls
+
+	// An identical request joins the one in flight
+	second := make(chan *Error, 1)
+	go func() {
+		second <- sf.Request(&nano_api.ReqPing{Id: 1}, &nano_api.ResPing{})
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	if err := <-first; err == nil || err.Category != "Context" {
+		t.Fatalf("expected a Context error for the cancelled caller, got %v", err)
+	}
+	reply <- nil
+	for {
+		select {
+		case err := <-second:
+			if err != nil {
+				t.Fatalf("expected the other caller to get the shared result, got %v", err)
+			}
+			return
+		case reply := <-r.calls:
+			// The caller didn't join in time, and sent the request itself
+			reply <- nil
+		}
+	}
+}